@@ -0,0 +1,162 @@
+package rattler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultArchiveConcurrency is the number of simultaneous downloads performed
+// by MediaArchiver, unless overridden.
+const DefaultArchiveConcurrency = 4
+
+// MediaArchiver downloads media embedded in tweets into a directory.
+//
+// Images of a gallery are stored as "<tweet id>_<n>.<ext>" with n starting at
+// 1, card preview images as "<tweet id>_card.<ext>" and GIFs as
+// "<tweet id>_gif.<ext>". Embedded videos and quotes are skipped, since they
+// can't be downloaded yet.
+type MediaArchiver struct {
+	root        string
+	concurrency int
+	client      *TwitterHTTP
+}
+
+// ArchiveResult is the result of archiving a single media file.
+//
+// Path is empty if the error occurred before a file could be created, e.g. if
+// the feed iterator reported an error.
+type ArchiveResult struct {
+	TweetID uint64
+	Path    string
+	Error   error
+}
+
+// archiveJob is a single file to be downloaded by MediaArchiver.
+type archiveJob struct {
+	tweetID  uint64
+	mediaURL string
+	path     string
+}
+
+// NewMediaArchiver creates an archiver that stores files under root directory,
+// performing at most concurrency downloads at once. Non-positive concurrency
+// means DefaultArchiveConcurrency.
+func NewMediaArchiver(root string, concurrency int) *MediaArchiver {
+	if concurrency <= 0 {
+		concurrency = DefaultArchiveConcurrency
+	}
+	return &MediaArchiver{
+		root:        root,
+		concurrency: concurrency,
+		client:      NewTwitterHTTP(),
+	}
+}
+
+// SetClient makes the archiver use given client for all downloads.
+func (t *MediaArchiver) SetClient(client *TwitterHTTP) {
+	t.client = client
+}
+
+// Archive downloads media of every tweet read from the feed iterator's channel.
+//
+// Returned channel receives a result for every file and for every error read
+// from the iterator. It's closed once the iterator's channel is drained and all
+// downloads have finished.
+func (t *MediaArchiver) Archive(tweets <-chan FeedIterResult) <-chan ArchiveResult {
+	resultChan := make(chan ArchiveResult, t.concurrency)
+	jobChan := make(chan archiveJob)
+
+	var workers sync.WaitGroup
+	for i := 0; i < t.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobChan {
+				resultChan <- ArchiveResult{
+					TweetID: job.tweetID,
+					Path:    job.path,
+					Error:   t.download(job),
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for result := range tweets {
+			if result.Error != nil {
+				resultChan <- ArchiveResult{Error: result.Error}
+				continue
+			}
+			for _, job := range t.jobs(result.Tweet) {
+				jobChan <- job
+			}
+		}
+		close(jobChan)
+		workers.Wait()
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+// jobs returns downloads needed to archive media of the tweet.
+func (t *MediaArchiver) jobs(tweet *Tweet) []archiveJob {
+	var jobs []archiveJob
+	switch extra := tweet.Extra.(type) {
+	case *TweetEmbeddedGallery:
+		for i, imageURL := range extra.ImageURLs {
+			name := fmt.Sprintf("%d_%d.%s", tweet.ID, i+1, mediaFileExt(imageURL))
+			jobs = append(jobs, archiveJob{
+				tweetID:  tweet.ID,
+				mediaURL: imageURL + ImageOrig.suffix(),
+				path:     filepath.Join(t.root, name),
+			})
+		}
+	case *TweetEmbeddedCard:
+		if len(extra.ImageURL) > 0 {
+			name := fmt.Sprintf("%d_card.%s", tweet.ID, mediaFileExt(extra.ImageURL))
+			jobs = append(jobs, archiveJob{
+				tweetID:  tweet.ID,
+				mediaURL: extra.ImageURL,
+				path:     filepath.Join(t.root, name),
+			})
+		}
+	case *TweetEmbeddedGIF:
+		if len(extra.VideoURL) > 0 {
+			name := fmt.Sprintf("%d_gif.%s", tweet.ID, mediaFileExt(extra.VideoURL))
+			jobs = append(jobs, archiveJob{
+				tweetID:  tweet.ID,
+				mediaURL: extra.VideoURL,
+				path:     filepath.Join(t.root, name),
+			})
+		}
+	}
+	return jobs
+}
+
+// download stores a single media file on disk. Partially written files are
+// removed.
+func (t *MediaArchiver) download(job archiveJob) error {
+	reader, err := downloadMedia(t.client, job.mediaURL)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(job.path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, reader); err != nil {
+		file.Close()
+		os.Remove(job.path)
+		return &MediaDownloadError{msg: "Failed to read media body", url: job.mediaURL, cause: err}
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(job.path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package rattler
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaArchiver(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing.jpg:orig" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, r.URL.Path)
+		}))
+	defer server.Close()
+
+	root, err := ioutil.TempDir("", "rattler-archive")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	twitterHTTP := NewTwitterHTTP()
+	twitterHTTP.httpClient = client
+	archiver := NewMediaArchiver(root, 2)
+	archiver.SetClient(twitterHTTP)
+
+	tweets := make(chan FeedIterResult, 6)
+	tweets <- FeedIterResult{Tweet: &Tweet{ID: 1, Extra: &TweetEmbeddedGallery{
+		ImageURLs: []string{"https://pbs.twimg.com/a.jpg", "https://pbs.twimg.com/b.png"},
+	}}}
+	tweets <- FeedIterResult{Tweet: &Tweet{ID: 2, Extra: &TweetEmbeddedCard{
+		CardURL:  "https://example.com/card",
+		ImageURL: "https://pbs.twimg.com/card.jpg",
+	}}}
+	tweets <- FeedIterResult{Tweet: &Tweet{ID: 3, Extra: &TweetEmbeddedVideo{"https://example.com/v"}}}
+	tweets <- FeedIterResult{Tweet: &Tweet{ID: 5, Extra: &TweetEmbeddedGIF{
+		VideoURL: "https://video.twimg.com/tweet_video/g.mp4",
+	}}}
+	tweets <- FeedIterResult{Tweet: &Tweet{ID: 4, Extra: &TweetEmbeddedGallery{
+		ImageURLs: []string{"https://pbs.twimg.com/missing.jpg"},
+	}}}
+	tweets <- FeedIterResult{Error: errors.New("iterator failed")}
+	close(tweets)
+
+	var paths []string
+	var failed []ArchiveResult
+	for result := range archiver.Archive(tweets) {
+		if result.Error != nil {
+			failed = append(failed, result)
+			continue
+		}
+		paths = append(paths, filepath.Base(result.Path))
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"1_1.jpg", "1_2.png", "2_card.jpg", "5_gif.mp4"}, paths)
+	require.Equal(t, 2, len(failed))
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "1_2.png"))
+	require.Nil(t, err)
+	assert.Equal(t, "/b.png:orig", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(root, "5_gif.mp4"))
+	require.Nil(t, err)
+	assert.Equal(t, "/tweet_video/g.mp4", string(data))
+
+	_, err = os.Stat(filepath.Join(root, "4_1.jpg"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,169 @@
+package rattler
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultBackfillWindow is the length of the time window BackfillSearch()
+// starts with, unless overridden.
+const DefaultBackfillWindow = 24 * time.Hour
+
+// DefaultBackfillPageCap is the number of pages BackfillSearch() retrieves
+// from a single window before it considers the window too large, unless
+// overridden.
+const DefaultBackfillPageCap = 50
+
+// minBackfillWindow is the length below which BackfillSearch() doesn't shrink
+// its window.
+const minBackfillWindow = time.Minute
+
+// BackfillResult is a result of BackfillSearch().
+//
+// Until is the upper bound of the time window being searched when the result
+// was emitted. Tweets posted before it may not have been emitted yet, so
+// calling BackfillSearch() with Until as the upper bound of the range resumes
+// the backfill. Some tweets of the window are emitted again in that case.
+type BackfillResult struct {
+	Tweet *Tweet
+	Error error
+	Until time.Time
+}
+
+// BackfillOption configures behaviour of BackfillSearch().
+type BackfillOption func(*backfillConfig)
+
+type backfillConfig struct {
+	client  *TwitterHTTP
+	window  time.Duration
+	pageCap int
+}
+
+// BackfillClient makes BackfillSearch() send requests through the given
+// client.
+func BackfillClient(client *TwitterHTTP) BackfillOption {
+	return func(c *backfillConfig) {
+		c.client = client
+	}
+}
+
+// BackfillWindow sets the length of the time window BackfillSearch() starts
+// with. It's also the length the window never grows beyond.
+func BackfillWindow(window time.Duration) BackfillOption {
+	return func(c *backfillConfig) {
+		c.window = window
+	}
+}
+
+// BackfillPageCap sets the number of pages retrieved from a single window,
+// after which the window is shrunk. It should be below the number of pages
+// Twitter serves for a single search.
+func BackfillPageCap(n int) BackfillOption {
+	return func(c *backfillConfig) {
+		c.pageCap = n
+	}
+}
+
+// BackfillSearch retrieves every tweet matching the query that was posted at or
+// after from and before to. Tweets are emitted roughly newest first and
+// duplicates are suppressed across the whole range.
+//
+// Twitter serves a limited number of pages for a single search, so the range
+// is searched in time windows that slide from to towards from. The latest
+// tweets of each window are searched first. When a window takes more than the
+// page cap to retrieve, the next window starts at the oldest tweet retrieved
+// so far and is half as long. Windows that take less than half of the cap
+// grow back.
+//
+// Errors are reported as the last result. The returned channel is closed once
+// the range has been searched, an error occurs or ctx is cancelled.
+func BackfillSearch(
+	ctx context.Context, query string, from, to time.Time, options ...BackfillOption,
+) <-chan BackfillResult {
+	config := backfillConfig{window: DefaultBackfillWindow, pageCap: DefaultBackfillPageCap}
+	for _, option := range options {
+		option(&config)
+	}
+	if config.client == nil {
+		config.client = NewTwitterHTTP()
+	}
+	if config.window < minBackfillWindow {
+		config.window = minBackfillWindow
+	}
+	if config.pageCap <= 0 {
+		config.pageCap = DefaultBackfillPageCap
+	}
+
+	resultChan := make(chan BackfillResult, 5)
+	go func() {
+		defer close(resultChan)
+		seen := newTweetIDLRU(DefaultDedupeCapacity)
+		window := config.window
+		end := to
+		for end.After(from) && ctx.Err() == nil {
+			start := end.Add(-window)
+			if start.Before(from) {
+				start = from
+			}
+
+			cursor := NewSearchFeedCursor(query)
+			cursor.SetClient(config.client)
+			cursor.SetMode(SearchLatest)
+			cursor.SetTimeRange(start, end)
+			session := NewTwitterSession(cursor, WithSeenTweets(seen))
+
+			var oldest time.Time
+			pages := 0
+			countPages := func(FeedPageReader, string) error {
+				pages++
+				return nil
+			}
+			for result := range session.FeedIter(Context(ctx), MaxPages(config.pageCap), OnPage(countPages)) {
+				if result.Error != nil && errors.Is(result.Error, ErrEmptyFeed) {
+					continue
+				}
+				if result.Tweet != nil && (oldest.IsZero() || result.Tweet.Timestamp.Before(oldest)) {
+					oldest = result.Tweet.Timestamp
+				}
+				select {
+				case resultChan <- BackfillResult{result.Tweet, result.Error, end}:
+				case <-ctx.Done():
+					session.Close()
+					return
+				}
+				if result.Error != nil {
+					session.Close()
+					return
+				}
+			}
+
+			if session.TerminationReason() != TerminationLimitReached {
+				end = start
+				if pages < config.pageCap/2 && window < config.window {
+					window *= 2
+					if window > config.window {
+						window = config.window
+					}
+				}
+				continue
+			}
+
+			// The window holds more tweets than the cap allows to retrieve.
+			// Search the rest of it in smaller windows. Until is exclusive and
+			// has a precision of one second, so the next window includes the
+			// second of the oldest tweet.
+			next := oldest.Truncate(time.Second).Add(time.Second)
+			if oldest.IsZero() || !next.Before(end) {
+				config.client.logger.WithField("until", end).
+					Warn("Unable to retrieve every tweet of backfill window, skipping the rest of it")
+				next = start
+			}
+			end = next
+			if window/2 >= minBackfillWindow {
+				window /= 2
+			}
+		}
+	}()
+	return resultChan
+}
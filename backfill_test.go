@@ -0,0 +1,130 @@
+package rattler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSearchServer serves search results from the given tweets, honoring
+// since: and until: operators of the query and returning pageSize tweets per
+// page.
+// Queries of the requests are appended to queries.
+func setupSearchServer(
+	t *testing.T, tweets []*Tweet, pageSize int, queries *[]string,
+) (*TwitterHTTP, *httptest.Server) {
+	rangeRegexp := regexp.MustCompile(`(since|until):(\S+)`)
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		*queries = append(*queries, query)
+		var since, until time.Time
+		for _, match := range rangeRegexp.FindAllStringSubmatch(query, -1) {
+			bound, err := time.Parse(searchTimeFormat, match[2])
+			require.Nil(t, err)
+			if match[1] == "since" {
+				since = bound
+			} else {
+				until = bound
+			}
+		}
+		maxPosition, _ := strconv.ParseUint(r.URL.Query().Get("max_position"), 10, 64)
+
+		var matching []*Tweet
+		for _, tweet := range tweets {
+			if tweet.Timestamp.Before(since) || !tweet.Timestamp.Before(until) {
+				continue
+			}
+			if maxPosition > 0 && tweet.ID >= maxPosition {
+				continue
+			}
+			matching = append(matching, tweet)
+		}
+		sort.Slice(matching, func(i, j int) bool { return matching[i].ID > matching[j].ID })
+
+		var html strings.Builder
+		minPosition := ""
+		for i, tweet := range matching {
+			if i == pageSize {
+				break
+			}
+			fmt.Fprintf(&html, `<li data-item-type="tweet" data-item-id="%d">`+
+				`<p class="tweet-text">%s</p><span data-time="%d"></span></li>`,
+				tweet.ID, tweet.Text, tweet.Timestamp.Unix())
+			minPosition = strconv.FormatUint(tweet.ID, 10)
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"items_html":     html.String(),
+			"min_position":   minPosition,
+			"has_more_items": len(matching) > pageSize,
+		})
+		w.Write(data)
+	})
+	return NewTwitterHTTP(WithTransport(client.Transport)), server
+}
+
+func TestBackfillSearch(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tweets []*Tweet
+	for i := 0; i < 30; i++ {
+		tweets = append(tweets, &Tweet{
+			ID:        uint64(i + 1),
+			Timestamp: base.Add(time.Duration(i) * 10 * time.Minute),
+			Text:      "test",
+		})
+	}
+	var queries []string
+	client, server := setupSearchServer(t, tweets, 3, &queries)
+	defer server.Close()
+
+	to := base.Add(300 * time.Minute)
+	var ids []uint64
+	var untils []time.Time
+	for result := range BackfillSearch(context.Background(), "test", base, to,
+		BackfillClient(client), BackfillWindow(2*time.Hour), BackfillPageCap(2)) {
+		require.Nil(t, result.Error)
+		ids = append(ids, result.Tweet.ID)
+		untils = append(untils, result.Until)
+	}
+
+	// Every tweet is emitted exactly once.
+	sorted := append([]uint64(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	require.Equal(t, 30, len(sorted))
+	for i, id := range sorted {
+		assert.Equal(t, uint64(i+1), id)
+	}
+
+	// Checkpoints only move backward in time.
+	assert.Equal(t, to, untils[0])
+	for i := 1; i < len(untils); i++ {
+		assert.False(t, untils[i].After(untils[i-1]))
+	}
+	assert.Contains(t, queries[0], "test since:2020-01-01_03:00:00_UTC until:2020-01-01_05:00:00_UTC")
+}
+
+func TestBackfillSearchCancel(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tweets := []*Tweet{{ID: 1, Timestamp: base, Text: "test"}, {ID: 2, Timestamp: base.Add(time.Hour), Text: "test"}}
+	var queries []string
+	client, server := setupSearchServer(t, tweets, 1, &queries)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := BackfillSearch(ctx, "test", base, base.Add(2*time.Hour), BackfillClient(client))
+	result := <-results
+	require.Nil(t, result.Error)
+	cancel()
+	for range results {
+	}
+}
@@ -0,0 +1,81 @@
+package rattler
+
+import (
+	"net/http"
+	"sync"
+)
+
+// CachedResponse is a response body stored along with the validators needed
+// to revalidate it with a conditional request.
+type CachedResponse struct {
+	ETag         string
+	LastModified string
+	Body         []byte
+}
+
+// ResponseCache stores responses for conditional requests made by TwitterHTTP.
+// Keys are request URLs. A cache shared by several clients has to be safe for
+// concurrent use.
+type ResponseCache interface {
+	Get(url string) (CachedResponse, bool)
+	Put(url string, response CachedResponse)
+}
+
+// MemoryResponseCache is a ResponseCache that keeps responses in memory. It's
+// safe for concurrent use.
+type MemoryResponseCache struct {
+	mutex     sync.Mutex
+	responses map[string]CachedResponse
+}
+
+// NewMemoryResponseCache creates an empty in-memory cache.
+func NewMemoryResponseCache() *MemoryResponseCache {
+	return &MemoryResponseCache{responses: make(map[string]CachedResponse)}
+}
+
+// Get returns the response cached for the URL.
+func (t *MemoryResponseCache) Get(url string) (CachedResponse, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	response, exists := t.responses[url]
+	return response, exists
+}
+
+// Put stores the response for the URL, replacing the previous one.
+func (t *MemoryResponseCache) Put(url string, response CachedResponse) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.responses[url] = response
+}
+
+// WithResponseCache makes the client revalidate responses stored in the cache
+// with If-None-Match and If-Modified-Since headers. When the server answers
+// with 304 Not Modified, the cached body is used as if it had been sent
+// again.
+//
+// Only responses to GET requests that carry ETag or Last-Modified header are
+// stored. Their bodies are kept decompressed.
+func WithResponseCache(cache ResponseCache) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.responseCache = cache
+	}
+}
+
+// addConditionalHeaders adds validators of the cached response to the request.
+// Returns false if there's no cached response for the request.
+func (t *TwitterHTTP) addConditionalHeaders(request *http.Request) (CachedResponse, bool) {
+	if t.responseCache == nil || request.Method != http.MethodGet {
+		return CachedResponse{}, false
+	}
+	cached, exists := t.responseCache.Get(request.URL.String())
+	if !exists {
+		return CachedResponse{}, false
+	}
+	if len(cached.ETag) > 0 {
+		request.Header.Set("If-None-Match", cached.ETag)
+	}
+	if len(cached.LastModified) > 0 {
+		request.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+	return cached, true
+}
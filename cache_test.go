@@ -0,0 +1,50 @@
+package rattler
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache(t *testing.T) {
+	notModified := 0
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			assert.Equal(t, "Mon, 02 Jan 2006 15:04:05 GMT", r.Header.Get("If-Modified-Since"))
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		fmt.Fprint(w, `{"test":true}`)
+	})
+	defer server.Close()
+
+	cache := NewMemoryResponseCache()
+	twitterHTTP := NewTwitterHTTP(WithTransport(client.Transport), WithResponseCache(cache))
+	for i := 0; i < 3; i++ {
+		request, err := twitterHTTP.newRequestS("https://twitter.com/test")
+		require.Nil(t, err)
+		structuredJSON, err := twitterHTTP.jsonRequest(request)
+		require.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"test": true}, structuredJSON)
+	}
+	assert.Equal(t, 2, notModified)
+
+	cached, exists := cache.Get("https://twitter.com/test")
+	require.True(t, exists)
+	assert.Equal(t, `"v1"`, cached.ETag)
+	assert.Equal(t, `{"test":true}`, string(cached.Body))
+
+	// Without a cache requests aren't conditional.
+	twitterHTTP = NewTwitterHTTP(WithTransport(client.Transport))
+	request, err := twitterHTTP.newRequestS("https://twitter.com/test")
+	require.Nil(t, err)
+	_, err = twitterHTTP.jsonRequest(request)
+	require.Nil(t, err)
+	assert.Equal(t, 2, notModified)
+}
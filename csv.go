@@ -0,0 +1,63 @@
+package rattler
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// TweetCSVWriter writes tweets as CSV records.
+//
+// Each record consists of tweet ID, timestamp (RFC 3339), text, embed type and
+// primary URL of the embedded object.
+type TweetCSVWriter struct {
+	writer *csv.Writer
+	out    io.Writer
+}
+
+// NewTweetCSVWriter creates a CSV writer that writes into w.
+func NewTweetCSVWriter(w io.Writer) *TweetCSVWriter {
+	return &TweetCSVWriter{
+		writer: csv.NewWriter(w),
+		out:    w,
+	}
+}
+
+// WriteHeader writes a record with column names.
+func (t *TweetCSVWriter) WriteHeader() error {
+	return t.writer.Write([]string{"id", "timestamp", "text", "embed_type", "embed_url"})
+}
+
+// Write writes a single tweet record.
+//
+// Records are buffered, call Flush() or Close() to make sure they reach the
+// underlying writer.
+func (t *TweetCSVWriter) Write(tweet *Tweet) error {
+	return t.writer.Write([]string{
+		strconv.FormatUint(tweet.ID, 10),
+		tweet.Timestamp.Format(time.RFC3339),
+		tweet.Text,
+		embedTypeName(tweet.Extra),
+		embedPrimaryURL(tweet.Extra),
+	})
+}
+
+// Flush writes any buffered data to the underlying writer and returns the
+// first error that occurred while writing.
+func (t *TweetCSVWriter) Flush() error {
+	t.writer.Flush()
+	return t.writer.Error()
+}
+
+// Close flushes buffered data and closes the underlying writer, if it
+// implements io.Closer.
+func (t *TweetCSVWriter) Close() error {
+	err := t.Flush()
+	if closer, ok := t.out.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -1,10 +1,21 @@
 package rattler
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
+var usernameRegexp = regexp.MustCompile("^[A-Za-z0-9_]{1,15}$")
+
 // FeedFilter enum represents a feed that is a target for scraping (regular or
 // media feed).
 type FeedFilter int
@@ -15,12 +26,86 @@ const (
 	// FeedTypeMedia is a media-only feed (contains only image/video/postcard
 	// tweets).
 	FeedTypeMedia FeedFilter = 1
+	// FeedTypeWithReplies is a feed of tweets and replies (contains replies
+	// hidden from the regular feed).
+	FeedTypeWithReplies FeedFilter = 2
+	// FeedTypeLikes is a feed of tweets liked by the user. Tweets in this feed
+	// are authored by other users.
+	FeedTypeLikes FeedFilter = 3
+)
+
+// feedFilterNames maps feed types to their names used by String() and
+// ParseFeedFilter().
+var feedFilterNames = map[FeedFilter]string{
+	FeedTypeRegular:     "regular",
+	FeedTypeMedia:       "media",
+	FeedTypeWithReplies: "replies",
+	FeedTypeLikes:       "likes",
+}
+
+// String returns name of the feed type (e.g. "media").
+func (f FeedFilter) String() string {
+	if name, ok := feedFilterNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("FeedFilter(%d)", int(f))
+}
+
+// ParseFeedFilter returns the feed type with given name, as returned by
+// String(). Names are case-insensitive.
+func ParseFeedFilter(name string) (FeedFilter, error) {
+	for feedType, feedName := range feedFilterNames {
+		if strings.EqualFold(name, feedName) {
+			return feedType, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown feed type '%s'", name)
+}
+
+// Direction enum represents the direction in which a cursor traverses a feed.
+type Direction int
+
+const (
+	// DirectionOlder makes cursor move from newer tweets to older ones. This
+	// is the default.
+	DirectionOlder Direction = 0
+	// DirectionNewer makes cursor move from the position towards newer
+	// tweets.
+	DirectionNewer Direction = 1
+)
+
+// SearchMode enum selects which kind of search results is retrieved by
+// SearchFeedCursor.
+type SearchMode int
+
+const (
+	// SearchTop retrieves results Twitter considers the most relevant. This
+	// is the default.
+	SearchTop SearchMode = iota
+	// SearchLatest retrieves all matching tweets in chronological order,
+	// which makes it the mode to use for exhaustive scraping.
+	SearchLatest
+	// SearchPhotos retrieves only tweets with images.
+	SearchPhotos
+	// SearchVideos retrieves only tweets with video.
+	SearchVideos
 )
 
+// searchModeParams maps search modes to the value of "f" request parameter.
+var searchModeParams = map[SearchMode]string{
+	SearchLatest: "tweets",
+	SearchPhotos: "images",
+	SearchVideos: "videos",
+}
+
 // FeedCursor is an interface for navigating a paginated Twitter feed.
+//
+// Position() returns a value that can be passed to Seek() or to the cursor
+// constructor's resumeAt argument in order to continue from the same place.
 type FeedCursor interface {
 	RetrievePage() (FeedPageReader, error)
 	Seek(string) bool
+	Position() string
 }
 
 // GenericFeedCursor is used for traversing any paginated feed that is not
@@ -33,6 +118,8 @@ type GenericFeedCursor struct {
 	client         *TwitterHTTP
 	username       string
 	feedType       FeedFilter
+	direction      Direction
+	pageSize       int
 	nextPageAnchor string
 }
 
@@ -40,6 +127,13 @@ type GenericFeedCursor struct {
 type SearchFeedCursor struct {
 	client         *TwitterHTTP
 	query          string
+	mode           SearchMode
+	sinceID        uint64
+	maxID          uint64
+	since          time.Time
+	until          time.Time
+	direction      Direction
+	pageSize       int
 	nextPageAnchor string
 }
 
@@ -80,96 +174,338 @@ func NewSearchFeedCursor(query string, resumeAt ...string) *SearchFeedCursor {
 	}
 }
 
+// Names of position attributes of pages and position parameters of requests.
+//
+// The names look swapped, but aren't: a page's min_position is the position of
+// its oldest tweet, and tweets older than that are requested by passing it as
+// max_position, i.e. "tweets up to this position". Traversing towards newer
+// tweets mirrors this: page's max_position is passed as min_position.
+//
+// A cursor's Position() is the value that will be sent with the next request,
+// which is the position attribute of the previous page.
+const (
+	pagePositionAttr      = "min_position"
+	newerPagePositionAttr = "max_position"
+	olderPositionParam    = "max_position"
+	newerPositionParam    = "min_position"
+)
+
+// addPositionParam adds the page position to request parameters according to
+// traversal direction.
+func addPositionParam(params url.Values, anchor string, direction Direction) {
+	if len(anchor) == 0 {
+		return
+	}
+	if direction == DirectionNewer {
+		params.Add(newerPositionParam, anchor)
+	} else {
+		params.Add(olderPositionParam, anchor)
+	}
+}
+
+// isValidUsername reports whether the string is a well-formed Twitter handle.
+func isValidUsername(username string) bool {
+	return usernameRegexp.MatchString(username)
+}
+
+// SetClient makes the cursor use given client for all requests, e.g. one
+// that was configured with authentication options.
+func (t *GenericFeedCursor) SetClient(client *TwitterHTTP) {
+	t.client = client
+}
+
+// SetClient makes the cursor use given client for all requests, e.g. one
+// that was configured with authentication options.
+func (t *SearchFeedCursor) SetClient(client *TwitterHTTP) {
+	t.client = client
+}
+
+// SetDirection sets the direction in which the cursor traverses the feed.
+//
+// With DirectionNewer the cursor retrieves tweets newer than its position,
+// e.g. to catch up with tweets posted since the position was recorded. The
+// direction isn't part of the position, so it has to be set again when
+// resuming.
+func (t *GenericFeedCursor) SetDirection(direction Direction) {
+	t.direction = direction
+}
+
+// SetDirection sets the direction in which the cursor traverses the feed. See
+// GenericFeedCursor.SetDirection().
+func (t *SearchFeedCursor) SetDirection(direction Direction) {
+	t.direction = direction
+}
+
+func (t *GenericFeedCursor) twitterHTTP() *TwitterHTTP {
+	return t.client
+}
+
+func (t *SearchFeedCursor) twitterHTTP() *TwitterHTTP {
+	return t.client
+}
+
 // RetrievePage downloads page at the current cursor position.
 //
-// Does not advance the cursor.
+// Does not advance the cursor. If the page can't be retrieved because the
+// account is suspended, protected or doesn't exist, the returned error is an
+// *AccountStateError. Malformed usernames are reported as
+// *InvalidUsernameError without making any requests.
 func (t *GenericFeedCursor) RetrievePage() (FeedPageReader, error) {
-	path := "/i/profiles/show/%s/%s"
-	if t.feedType == FeedTypeRegular {
-		path = fmt.Sprintf(path, t.username, "timeline")
-	} else if t.feedType == FeedTypeMedia {
-		path = fmt.Sprintf(path, t.username, "media_timeline")
-	} else {
+	request, err := t.RequestPreview()
+	if err != nil {
+		return nil, err
+	}
+	structuredJSON, meta, err := t.client.jsonRequestMeta(request)
+	if err != nil {
+		return nil, t.diagnoseError(err)
+	}
+	page := NewFeedPage(structuredJSON)
+	if page == nil {
+		return nil, &URLError{msg: "Failed to create GenericTimelinePage", url: request.URL.String()}
+	}
+	page.logger = t.client.logger
+	page.size = meta.size
+	page.header = meta.header
+	page.selectors = t.client.selectors
+	return directedPage(page, t.direction), nil
+}
+
+// RequestPreview returns the request RetrievePage() would send at the current
+// cursor position without sending it.
+//
+// Cookies are added by the HTTP client when the request is sent, so they're
+// not included.
+func (t *GenericFeedCursor) RequestPreview() (*http.Request, error) {
+	if !isValidUsername(t.username) {
+		return nil, &InvalidUsernameError{t.username}
+	}
+
+	var path, referrerPath string
+	switch t.feedType {
+	case FeedTypeRegular:
+		path = fmt.Sprintf("/i/profiles/show/%s/timeline", t.username)
+		referrerPath = "/" + t.username
+	case FeedTypeMedia:
+		path = fmt.Sprintf("/i/profiles/show/%s/media_timeline", t.username)
+		referrerPath = "/" + t.username + "/media"
+	case FeedTypeWithReplies:
+		path = fmt.Sprintf("/i/profiles/show/%s/timeline/with_replies", t.username)
+		referrerPath = "/" + t.username + "/with_replies"
+	case FeedTypeLikes:
+		path = fmt.Sprintf("/%s/likes/timeline", t.username)
+		referrerPath = "/" + t.username + "/likes"
+	default:
 		panic("Unknown timeline type!")
 	}
 
 	params := make(url.Values)
 	params.Add("include_available_features", "1")
 	params.Add("include_entities", "1")
-	if len(t.nextPageAnchor) > 0 {
-		params.Add("max_position", t.nextPageAnchor)
+	if t.pageSize > 0 {
+		params.Add("count", strconv.Itoa(t.pageSize))
 	}
+	addPositionParam(params, t.nextPageAnchor, t.direction)
 	params.Add("reset_error_state", "false")
 
-	aURL := url.URL{
-		Scheme:   "https",
-		Host:     "twitter.com",
-		Path:     path,
-		RawQuery: params.Encode(),
-	}
-
+	aURL := t.client.endpoint(path, params)
 	request, err := t.client.newRequest(aURL)
 	if err != nil {
 		return nil, err
 	}
 
-	var referrer string
-	if t.feedType == FeedTypeMedia {
-		referrer = fmt.Sprintf("https://twitter.com/%s/media", t.username)
-	} else {
-		referrer = fmt.Sprintf("https://twitter.com/%s", t.username)
-	}
-
-	request.Header.Set("Referer", referrer)
+	referrerURL := t.client.endpoint(referrerPath, nil)
+	request.Header.Set("Referer", referrerURL.String())
 	request.Header.Set("Accept", "application/json,text/javascript,*/*;q=0.01")
 	request.Header.Set("X-Requested-With", "XMLHttpRequest")
+	return request, nil
+}
 
-	structuredJSON, err := t.client.jsonRequest(request)
+// logFields returns log fields identifying the feed.
+func (t *GenericFeedCursor) logFields() log.Fields {
+	return log.Fields{"username": t.username, "feed": t.feedType.String()}
+}
+
+// logFields returns log fields identifying the feed.
+func (t *SearchFeedCursor) logFields() log.Fields {
+	return log.Fields{"query": t.fullQuery(), "feed": "search"}
+}
+
+// diagnoseError checks whether the error returned by timeline endpoint was
+// caused by the state of user's account. If so, an *AccountStateError is
+// returned instead of the original error.
+func (t *GenericFeedCursor) diagnoseError(err error) error {
+	var urlErr *URLError
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+
+	var syntaxErr *json.SyntaxError
+	switch urlErr.StatusCode() {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+	default:
+		// A non-JSON response is served in place of the timeline if the
+		// account is unavailable.
+		if !errors.As(err, &syntaxErr) {
+			return err
+		}
+	}
+
+	profile, profileErr := t.client.FetchProfile(t.username)
+	if profileErr != nil {
+		var stateErr *AccountStateError
+		if errors.As(profileErr, &stateErr) {
+			return stateErr
+		}
+		return err
+	}
+	if profile.Protected {
+		return &AccountStateError{t.username, AccountProtected}
+	}
+	return err
+}
+
+// Bounds of the number of tweets per page accepted by Twitter.
+const (
+	maxTimelinePageSize = 200
+	maxSearchPageSize   = 100
+)
+
+// clampPageSize limits the page size to the range accepted by Twitter. Zero
+// and negative sizes mean the server's default.
+func clampPageSize(n, max int) int {
+	if n <= 0 {
+		return 0
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// SetPageSize asks Twitter for pages of n tweets, which is sent as count
+// parameter, to save requests on large feeds. The size is clamped to at most
+// 200 tweets. Non-positive n restores the default of about 20 tweets.
+//
+// The size is only a hint. Twitter may ignore large values or return fewer
+// tweets, e.g. when some of them are withheld.
+func (t *GenericFeedCursor) SetPageSize(n int) {
+	t.pageSize = clampPageSize(n, maxTimelinePageSize)
+}
+
+// SetPageSize asks Twitter for pages of n tweets, see
+// GenericFeedCursor.SetPageSize(). Search pages are clamped to at most 100
+// tweets.
+func (t *SearchFeedCursor) SetPageSize(n int) {
+	t.pageSize = clampPageSize(n, maxSearchPageSize)
+}
+
+// SetMode sets which kind of search results the cursor retrieves. Like the
+// direction, the mode isn't part of the position.
+func (t *SearchFeedCursor) SetMode(mode SearchMode) {
+	t.mode = mode
+}
+
+// SetIDRange restricts search results to tweets with IDs greater than sinceID
+// and less than or equal to maxID. Zero value leaves the corresponding bound
+// open.
+//
+// Since tweet IDs grow over time, this allows to split exhaustive backfills
+// into deterministic chunks, which can be resumed by ID.
+func (t *SearchFeedCursor) SetIDRange(sinceID, maxID uint64) {
+	t.sinceID = sinceID
+	t.maxID = maxID
+}
+
+// SetTimeRange restricts search results to tweets posted at or after since and
+// before until. Zero value leaves the corresponding bound open. Bounds have
+// a precision of one second.
+func (t *SearchFeedCursor) SetTimeRange(since, until time.Time) {
+	t.since = since
+	t.until = until
+}
+
+// searchTimeFormat is the format of since: and until: search operators that
+// Twitter accepts with a precision finer than a day.
+const searchTimeFormat = "2006-01-02_15:04:05_UTC"
+
+// fullQuery returns search query with all restrictions applied.
+func (t *SearchFeedCursor) fullQuery() string {
+	query := t.query
+	if t.sinceID > 0 {
+		query += fmt.Sprintf(" since_id:%d", t.sinceID)
+	}
+	if t.maxID > 0 {
+		query += fmt.Sprintf(" max_id:%d", t.maxID)
+	}
+	if !t.since.IsZero() {
+		query += " since:" + t.since.UTC().Format(searchTimeFormat)
+	}
+	if !t.until.IsZero() {
+		query += " until:" + t.until.UTC().Format(searchTimeFormat)
+	}
+	return query
+}
+
+// RetrievePage downloads page at the current cursor position.
+//
+// Does not advance the cursor.
+func (t *SearchFeedCursor) RetrievePage() (FeedPageReader, error) {
+	request, err := t.RequestPreview()
+	if err != nil {
+		return nil, err
+	}
+	structuredJSON, meta, err := t.client.jsonRequestMeta(request)
 	if err != nil {
 		return nil, err
 	}
 	page := NewFeedPage(structuredJSON)
 	if page == nil {
-		return nil, &URLError{"Failed to create GenericTimelinePage", aURL.String(), nil}
+		return nil, &URLError{msg: "Failed to create GenericTimelinePage", url: request.URL.String()}
 	}
-	return page, nil
+	page.logger = t.client.logger
+	page.size = meta.size
+	page.header = meta.header
+	page.selectors = t.client.selectors
+	return directedPage(page, t.direction), nil
 }
 
-// RetrievePage downloads page at the current cursor position.
+// RequestPreview returns the request RetrievePage() would send at the current
+// cursor position without sending it.
 //
-// Does not advance the cursor.
-func (t *SearchFeedCursor) RetrievePage() (FeedPageReader, error) {
+// Cookies are added by the HTTP client when the request is sent, so they're
+// not included.
+func (t *SearchFeedCursor) RequestPreview() (*http.Request, error) {
+	query := t.fullQuery()
 	params := make(url.Values)
+	filter, hasFilter := searchModeParams[t.mode]
+	if hasFilter {
+		params.Add("f", filter)
+	}
 	params.Add("vertical", "default")
-	params.Add("q", t.query)
+	params.Add("q", query)
+	if len(t.client.language) > 0 {
+		params.Add("lang", strings.ToLower(t.client.primaryLanguage()))
+	}
 	params.Add("include_available_features", "1")
 	params.Add("include_entities", "1")
-	if len(t.nextPageAnchor) > 0 {
-		params.Add("max_position", t.nextPageAnchor)
+	if t.pageSize > 0 {
+		params.Add("count", strconv.Itoa(t.pageSize))
 	}
+	addPositionParam(params, t.nextPageAnchor, t.direction)
 	params.Add("reset_error_state", "false")
-	aURL := url.URL{
-		Scheme:   "https",
-		Host:     "twitter.com",
-		Path:     "/i/search/timeline",
-		RawQuery: params.Encode(),
-	}
-
+	aURL := t.client.endpoint("/i/search/timeline", params)
 	request, err := t.client.newRequest(aURL)
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Add("Referer", fmt.Sprintf("https://twitter.com/search?q=%s", t.query))
-	request.Header.Add("Accept", "application/json,text/javascript,*/*;q=0.01")
-	structuredJSON, err := t.client.jsonRequest(request)
-	if err != nil {
-		return nil, err
-	}
-	page := NewFeedPage(structuredJSON)
-	if page == nil {
-		return nil, &URLError{"Failed to create GenericTimelinePage", aURL.String(), nil}
+	referrerParams := url.Values{"q": []string{query}}
+	if hasFilter {
+		referrerParams.Add("f", filter)
 	}
-	return page, nil
+	referrerURL := t.client.endpoint("/search", referrerParams)
+	request.Header.Add("Referer", referrerURL.String())
+	request.Header.Add("Accept", "application/json,text/javascript,*/*;q=0.01")
+	return request, nil
 }
 
 // Seek positions cursor at given position within feed.
@@ -189,3 +525,29 @@ func (t *SearchFeedCursor) Seek(position string) bool {
 	t.nextPageAnchor = position
 	return true
 }
+
+// rewind positions cursor at the beginning of the feed.
+func (t *GenericFeedCursor) rewind() {
+	t.nextPageAnchor = ""
+}
+
+// rewind positions cursor at the beginning of the feed.
+func (t *SearchFeedCursor) rewind() {
+	t.nextPageAnchor = ""
+}
+
+// Position returns the current position within feed. An empty string means
+// the cursor points at the beginning of the feed.
+//
+// The position is the anchor sent with the next request, i.e. the
+// min_position of the last page (sent as max_position), not the position of
+// the last page itself.
+func (t *GenericFeedCursor) Position() string {
+	return t.nextPageAnchor
+}
+
+// Position returns the current position within feed. An empty string means
+// the cursor points at the beginning of the feed.
+func (t *SearchFeedCursor) Position() string {
+	return t.nextPageAnchor
+}
@@ -1,19 +1,39 @@
 package rattler
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrEmptyFeed is reported by FeedIter() when the feed has been retrieved
+// successfully but contains no tweets at all.
+var ErrEmptyFeed = errors.New("Feed is empty")
+
+// ErrSessionClosed is reported by iterators started on a session that has
+// been closed with Close().
+var ErrSessionClosed = errors.New("Session is closed")
+
 // APICompatError occurs when the process of extracting scraped data was
 // unsuccessful. This is most likely the result of Twitter changing its
 // internal interfaces or bug in the parser.
+//
+// Selector and Snippet describe which part of the markup couldn't be
+// processed and are meant to help with diagnosing changes in Twitter's HTML.
 type APICompatError struct {
-	msg     string
-	tweetID *uint64
+	msg      string
+	tweetID  *uint64
+	selector string
+	snippet  string
 }
 
 // URLError is an error that can happen while fetching or parsing
 // data from the remote server.
 type URLError struct {
-	msg   string
-	url   string
-	cause error
+	msg        string
+	url        string
+	cause      error
+	statusCode int
 }
 
 // MediaDownloadError is an error that happens when downloading embedded
@@ -24,7 +44,59 @@ type MediaDownloadError struct {
 	cause error
 }
 
+// BlockedError occurs when Twitter appears to serve placeholder responses
+// instead of the feed, which happens when it soft-blocks a scraper. Such
+// responses are successful, but either don't advance the position within the
+// feed or keep claiming there are more tweets without including any.
+type BlockedError struct {
+	position string
+	reason   string
+}
+
+// PaginationLoopError occurs when a page points back at a position of the
+// feed that has already been retrieved, which would make the iteration run
+// forever.
+type PaginationLoopError struct {
+	position string
+}
+
+// AccountState describes availability of a Twitter account.
+type AccountState int
+
+const (
+	// AccountOK means that the account is available.
+	AccountOK AccountState = iota
+	// AccountNotFound means that the account doesn't exist.
+	AccountNotFound
+	// AccountSuspended means that the account has been suspended by Twitter.
+	AccountSuspended
+	// AccountProtected means that the account's tweets are visible only to
+	// approved followers.
+	AccountProtected
+)
+
+// AccountStateError occurs when a Twitter account can't be accessed because
+// of its state.
+type AccountStateError struct {
+	username string
+	state    AccountState
+}
+
+// InvalidUsernameError occurs when a string passed as username can't be a
+// Twitter handle.
+type InvalidUsernameError struct {
+	username string
+}
+
+// TweetNotFoundError occurs when a tweet doesn't exist or has been deleted.
+type TweetNotFoundError struct {
+	tweetID uint64
+}
+
 func (e *APICompatError) Error() string {
+	if len(e.selector) > 0 {
+		return fmt.Sprintf("%s (selector: %s)", e.msg, e.selector)
+	}
 	return e.msg
 }
 
@@ -33,6 +105,18 @@ func (e *APICompatError) TwitterID() *uint64 {
 	return e.tweetID
 }
 
+// Selector returns CSS selector of the markup that failed to be processed or
+// an empty string if the error isn't tied to a particular selector.
+func (e *APICompatError) Selector() string {
+	return e.selector
+}
+
+// Snippet returns the outer HTML (possibly truncated) of the node that failed
+// to be processed.
+func (e *APICompatError) Snippet() string {
+	return e.snippet
+}
+
 func (e *URLError) Error() string {
 	return e.msg
 }
@@ -47,6 +131,29 @@ func (e *URLError) Cause() error {
 	return e.cause
 }
 
+// StatusCode returns HTTP status code of the response that caused the error
+// or 0 if the error isn't related to an unsuccessful HTTP response.
+func (e *URLError) StatusCode() int {
+	return e.statusCode
+}
+
+// IsRateLimited reports whether the server refused the request because of
+// too many requests (HTTP 429).
+func (e *URLError) IsRateLimited() bool {
+	return e.statusCode == http.StatusTooManyRequests
+}
+
+// IsNotFound reports whether the requested resource doesn't exist (HTTP 404).
+func (e *URLError) IsNotFound() bool {
+	return e.statusCode == http.StatusNotFound
+}
+
+// Unwrap returns inner error object, allowing errors.Is() and errors.As() to
+// inspect it.
+func (e *URLError) Unwrap() error {
+	return e.cause
+}
+
 func (t *MediaDownloadError) Error() string {
 	return t.msg
 }
@@ -60,3 +167,68 @@ func (t *MediaDownloadError) URL() string {
 func (t *MediaDownloadError) Cause() error {
 	return t.cause
 }
+
+// Unwrap returns cause of the error, allowing errors.Is() and errors.As() to
+// inspect it.
+func (t *MediaDownloadError) Unwrap() error {
+	return t.cause
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("Twitter appears to block the requests: %s", e.reason)
+}
+
+// Position returns the position of the feed that was being retrieved.
+func (e *BlockedError) Position() string {
+	return e.position
+}
+
+func (e *PaginationLoopError) Error() string {
+	return fmt.Sprintf("Pagination loop detected: position '%s' has already been retrieved", e.position)
+}
+
+// Position returns the position the page pointed back at.
+func (e *PaginationLoopError) Position() string {
+	return e.position
+}
+
+func (e *AccountStateError) Error() string {
+	switch e.state {
+	case AccountNotFound:
+		return fmt.Sprintf("Account '%s' does not exist", e.username)
+	case AccountSuspended:
+		return fmt.Sprintf("Account '%s' has been suspended", e.username)
+	case AccountProtected:
+		return fmt.Sprintf("Account '%s' is protected", e.username)
+	}
+	return fmt.Sprintf("Account '%s' is not accessible", e.username)
+}
+
+// Username returns username of the account.
+func (e *AccountStateError) Username() string {
+	return e.username
+}
+
+// State returns state of the account.
+func (e *AccountStateError) State() AccountState {
+	return e.state
+}
+
+func (e *InvalidUsernameError) Error() string {
+	return fmt.Sprintf("Invalid username '%s': expected 1-15 letters, digits or underscores",
+		e.username)
+}
+
+// Username returns the offending username.
+func (e *InvalidUsernameError) Username() string {
+	return e.username
+}
+
+func (e *TweetNotFoundError) Error() string {
+	return fmt.Sprintf("Tweet %d does not exist", e.tweetID)
+}
+
+// TweetID returns ID of the missing tweet.
+func (e *TweetNotFoundError) TweetID() uint64 {
+	return e.tweetID
+}
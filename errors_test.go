@@ -0,0 +1,24 @@
+package rattler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorUnwrap(t *testing.T) {
+	urlErr := &URLError{
+		msg:   "Failed to execute HTTP request",
+		url:   "https://example.com",
+		cause: context.DeadlineExceeded,
+	}
+	var err error = &MediaDownloadError{"Failed to download", "https://example.com", urlErr}
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	var target *URLError
+	assert.True(t, errors.As(err, &target))
+	assert.Equal(t, "https://example.com", target.URL())
+}
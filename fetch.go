@@ -0,0 +1,56 @@
+package rattler
+
+import (
+	"errors"
+	"fmt"
+
+	gq "github.com/PuerkitoBio/goquery"
+)
+
+// FetchTweet retrieves a single tweet by its ID from the tweet's status page.
+//
+// If the tweet doesn't exist or has been deleted, the returned error is a
+// *TweetNotFoundError.
+func (t *TwitterHTTP) FetchTweet(id uint64) (*Tweet, error) {
+	aURL := t.endpoint(fmt.Sprintf("/i/status/%d", id), nil)
+
+	request, err := t.newRequest(aURL)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.do(request)
+	if err != nil {
+		var urlErr *URLError
+		if errors.As(err, &urlErr) && urlErr.IsNotFound() {
+			return nil, &TweetNotFoundError{id}
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	doc, err := gq.NewDocumentFromReader(response.Body)
+	if err != nil {
+		return nil, &URLError{msg: "Unable to parse tweet HTML", url: aURL.String(), cause: err}
+	}
+	return extractPermalinkTweet(id, doc.Selection, &FeedPage{logger: t.logger, selectors: t.selectors})
+}
+
+// extractPermalinkTweet extracts the tweet with given ID from its status page.
+func extractPermalinkTweet(id uint64, sel *gq.Selection, page *FeedPage) (*Tweet, error) {
+	containerSel := sel.Find(page.css().PermalinkTweet)
+	if containerSel.Length() == 0 {
+		return nil, newAPICompatError("Permalink tweet not found",
+			page.css().PermalinkTweet, sel, &id)
+	}
+
+	tweet, err := page.extractTweet(containerSel.First())
+	if err != nil {
+		return nil, err
+	}
+	if tweet.ID != id {
+		msg := fmt.Sprintf("Status page holds tweet %d instead of %d", tweet.ID, id)
+		return nil, newAPICompatError(msg, page.css().PermalinkTweet, containerSel, &id)
+	}
+	return tweet, nil
+}
@@ -0,0 +1,58 @@
+package rattler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPermalinkHTML = `
+<html><body>
+<div class="permalink-container">
+	<div class="permalink-tweet-container">
+		<div class="tweet permalink-tweet" data-tweet-id="991826226405818368"
+			data-screen-name="Twitter" data-conversation-id="991826226405818368">
+			<span class="_timestamp" data-time="1525304774"></span>
+			<p class="tweet-text" lang="en">Hello, world</p>
+		</div>
+	</div>
+	<div class="replies-to">
+		<li data-item-type="tweet" data-item-id="991826226405818369">
+			<p class="tweet-text">A reply</p>
+		</li>
+	</div>
+</div>
+</body></html>`
+
+func TestFetchTweet(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/i/status/991826226405818368":
+				fmt.Fprint(w, testPermalinkHTML)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer server.Close()
+
+	twitterHTTP := NewTwitterHTTP()
+	twitterHTTP.httpClient = client
+
+	tweet, err := twitterHTTP.FetchTweet(991826226405818368)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(991826226405818368), tweet.ID)
+	assert.Equal(t, "Hello, world", tweet.Text)
+	assert.Equal(t, "Twitter", tweet.Username)
+	assert.Equal(t, "en", tweet.Lang)
+	assert.Equal(t, int64(1525304774), tweet.Timestamp.Unix())
+
+	_, err = twitterHTTP.FetchTweet(1)
+	var notFound *TweetNotFoundError
+	require.True(t, errors.As(err, &notFound))
+	assert.Equal(t, uint64(1), notFound.TweetID())
+}
@@ -1,63 +1,476 @@
 package rattler
 
-import log "github.com/sirupsen/logrus"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
 
 // FeedIterResult is the result of calling FeedIterResult() to retrieve a single tweet
 // from feed.
+//
+// Position is the cursor position of the page the tweet came from. Passing it
+// to cursor's resumeAt argument restarts scraping from that page, so a
+// consumer that records Position after processing each tweet won't lose the
+// remainder of a page if it crashes midway. Tweets from the same page share
+// the same Position.
 type FeedIterResult struct {
-	Tweet *Tweet
-	Error error
+	Tweet    *Tweet
+	Error    error
+	Position string
+}
+
+// FeedIterOption configures behaviour of FeedIter().
+type FeedIterOption func(*feedIterConfig)
+
+type feedIterConfig struct {
+	singlePage    bool
+	disableDedupe bool
+	languages     []string
+	filters       []FilterFunc
+	onPage        []PageFunc
+	reorderWindow int
+	maxPages      int
+	maxTweets     int
+	maxDuration   time.Duration
+	waitRateLimit bool
+	done          <-chan struct{}
+
+	progressEvery int
+	onProgress    ProgressFunc
+}
+
+// Progress describes how far an iteration has got.
+//
+// Timestamp is the timestamp of the last tweet emitted so far. Since feeds are
+// retrieved newest first, it moves backward in time as the iteration
+// proceeds and gives a rough idea of how much of the feed remains.
+type Progress struct {
+	PagesFetched  int
+	TweetsEmitted int
+	Timestamp     time.Time
+	Elapsed       time.Duration
+}
+
+// ProgressFunc receives progress reports of FeedIter().
+type ProgressFunc func(Progress)
+
+// FilterFunc decides whether a tweet should be emitted by FeedIter().
+type FilterFunc func(*Tweet) bool
+
+// PageFunc is called by FeedIter() for every retrieved page along with the
+// position the page was retrieved from.
+type PageFunc func(page FeedPageReader, position string) error
+
+// SinglePage stops iteration after the first page of the feed. It replaces
+// the boolean argument FeedIter() used to take, i.e. FeedIter(true) becomes
+// FeedIter(SinglePage()).
+func SinglePage() FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.singlePage = true
+	}
+}
+
+// MaxPages stops iteration after n pages have been retrieved. Non-positive n
+// means no limit.
+//
+// Limits set by MaxPages(), MaxTweets() and MaxDuration() can be combined, in
+// which case iteration stops as soon as any of them is reached. When stopped
+// by a limit, scraping can be resumed by calling FeedIter() again. MaxPages()
+// and MaxDuration() leave session's cursor at the page following the last
+// emitted one. MaxTweets() may stop in the middle of a page, so the cursor is
+// moved back to the page of the last emitted tweet, whose tweets emitted
+// earlier are dropped as duplicates on resume unless deduplication is
+// disabled.
+func MaxPages(n int) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.maxPages = n
+	}
+}
+
+// MaxTweets stops iteration after n tweets have been emitted. Tweets rejected
+// by filters or dropped as duplicates don't count. Non-positive n means no
+// limit.
+func MaxTweets(n int) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.maxTweets = n
+	}
+}
+
+// MaxDuration stops retrieving further pages once the given wall-clock time
+// has elapsed since FeedIter() was called. A page whose retrieval is in
+// progress is still retrieved and its tweets are emitted before the channel
+// is closed. Non-positive duration means no limit.
+//
+// Unlike WithTimeout(), which limits a single request, the duration applies
+// to the whole iteration.
+func MaxDuration(duration time.Duration) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.maxDuration = duration
+	}
+}
+
+// Context stops the iteration once the context is done and closes the
+// channel, setting TerminationReason() to TerminationCancelled. A consumer
+// that stops receiving before the channel is closed should cancel the context,
+// otherwise the iterator can't tell it has been abandoned and keeps waiting
+// for the consumer until the session is closed.
+func Context(ctx context.Context) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.done = ctx.Done()
+	}
+}
+
+// WaitForRateLimit makes the iterator wait until the rate limit window resets
+// before retrieving the next page when the last page reports that no requests
+// remain (see FeedPage.RateLimit()). Pages that don't report rate limits are
+// followed as usual.
+func WaitForRateLimit() FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.waitRateLimit = true
+	}
+}
+
+// DisableDedupe makes the iterator emit every tweet it encounters, even if a
+// tweet with the same ID has already been emitted by the session.
+//
+// Seen tweet IDs are not recorded when deduplication is disabled, which keeps
+// memory usage flat on very long runs.
+func DisableDedupe() FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.disableDedupe = true
+	}
+}
+
+// Languages makes the iterator emit only tweets in one of the given languages.
+//
+// Languages are BCP 47 codes matched against Tweet.Lang case-insensitively. A
+// code without region (e.g. "es") also matches regional variants ("es-419").
+// Passing no codes disables the filter.
+func Languages(codes ...string) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.languages = codes
+	}
+}
+
+// Filter makes the iterator emit only tweets for which the function returns
+// true. The option may be passed several times, in which case a tweet has to
+// pass every filter.
+//
+// Filters run after duplicate suppression.
+func Filter(filter FilterFunc) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.filters = append(c.filters, filter)
+	}
+}
+
+// OnPage makes the iterator call the function after each page is retrieved,
+// e.g. to checkpoint progress. The option may be passed several times.
+//
+// The function runs in iterator's background goroutine before the page's
+// tweets are emitted, so a slow function delays retrieval of further pages. If
+// it returns an error, the iteration stops and the error is reported as the
+// last result.
+func OnPage(callback PageFunc) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.onPage = append(c.onPage, callback)
+	}
+}
+
+// OnProgress makes the iterator call the function after every n pages have
+// been processed. Unlike Stats(), the reported counters cover only the
+// iteration itself.
+//
+// The function runs in iterator's background goroutine after tweets of the
+// page have been processed, so it should return quickly.
+func OnProgress(n int, callback ProgressFunc) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.progressEvery = n
+		c.onProgress = callback
+	}
+}
+
+// OrderByTimestamp makes the iterator emit tweets newest first by buffering up
+// to window tweets and reordering them. Tweets that are more than window
+// positions out of order are still emitted out of order.
+//
+// Without this option tweets are emitted in the order they appear on pages,
+// pages in the order they are retrieved. That order is already chronological
+// for a single feed, apart from pinned tweets, so the option is mostly useful
+// with cursors that merge several feeds.
+//
+// Position of a reordered result is the position of the oldest page that
+// still has tweets buffered, so it stays safe for resuming.
+func OrderByTimestamp(window int) FeedIterOption {
+	return func(c *feedIterConfig) {
+		c.reorderWindow = window
+	}
+}
+
+// HasMedia is a FilterFunc that accepts tweets with embedded images or video.
+func HasMedia(tweet *Tweet) bool {
+	return tweet.HasMedia()
+}
+
+// ExcludeRetweets is a FilterFunc that rejects retweets.
+func ExcludeRetweets(tweet *Tweet) bool {
+	return !tweet.IsRetweet
+}
+
+// accepts reports whether the tweet passes all filters of the config.
+func (c *feedIterConfig) accepts(tweet *Tweet) bool {
+	if len(c.languages) > 0 && !matchesLanguage(tweet.Lang, c.languages) {
+		return false
+	}
+	for _, filter := range c.filters {
+		if !filter(tweet) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesLanguage(lang string, codes []string) bool {
+	for _, code := range codes {
+		if strings.EqualFold(lang, code) {
+			return true
+		}
+		if len(lang) > len(code) && lang[len(code)] == '-' &&
+			strings.EqualFold(lang[:len(code)], code) {
+			return true
+		}
+	}
+	return false
 }
 
 // FeedIter returns a channel which can be used to read all available
 // feed tweets.
 //
+// If the very first page of the feed contains no tweets, the last result
+// carries ErrEmptyFeed when the feed is genuinely empty, or an APICompatError
+// when the page has content, but no tweets could be found in it.
+//
+// When Twitter appears to soft-block the requests, by serving pages that don't
+// advance the position or that keep claiming there are more tweets without
+// including any, the last result carries *BlockedError. A page pointing back
+// at a position retrieved earlier ends the iteration with
+// *PaginationLoopError.
+//
 // Using FeedIter() is the recommended way for scraping tweet data.
 //
+// Tweets are emitted in the order they appear on each page and pages in the
+// order they are retrieved, unless OrderByTimestamp() is used.
+//
 // Depending on cursor used, not all available tweets may be retrieved by the
 // iterator. Twitter puts a hard limit on a maximum number tweets in a feed.
 // So far, the only known way to completely retrieve the entire twitter feed
 // is to iterate over the feed using a search query with a sliding time range
-// until no tweets are getting returned.
-func (t *TwitterSession) FeedIter(singlePage ...bool) <-chan (FeedIterResult) {
+// until no tweets are getting returned, which is what BackfillSearch() does.
+//
+// Behaviour of the iterator can be tuned by passing FeedIterOption values.
+//
+// A panic raised while retrieving or processing pages, including one raised by
+// a FilterFunc, doesn't crash the program. It's reported as the last result's
+// error instead.
+//
+// Closing the session stops the iterator and closes the channel. On a session
+// that's already closed the only result carries ErrSessionClosed. To stop a
+// single iteration early, pass Context() and cancel the context.
+//
+// Once the channel is closed, TerminationReason() tells why the iteration
+// ended, e.g. whether it's worth resuming.
+func (t *TwitterSession) FeedIter(options ...FeedIterOption) <-chan (FeedIterResult) {
 	type pageIter struct {
-		page FeedPageReader
-		err  error
+		page     FeedPageReader
+		err      error
+		position string
 	}
 	tweetChan := make(chan (FeedIterResult), 5)
 	pageChan := make(chan (pageIter), 1)
 	pageOut := make(chan (interface{}))
+	producerDone := make(chan struct{})
 
-	// Stop download after 1 page if requested by the caller.
-	onlyOnePage := len(singlePage) == 1 && singlePage[0]
+	var config feedIterConfig
+	for _, option := range options {
+		option(&config)
+	}
+
+	start := time.Now()
+	if !t.startWorkers(2) {
+		t.setTermination(TerminationError)
+		tweetChan <- FeedIterResult{Error: ErrSessionClosed, Position: t.cursor.Position()}
+		close(tweetChan)
+		return tweetChan
+	}
+	t.setTermination(TerminationNone)
+
+	// Reason why the producer stopped retrieving pages. It's read by the
+	// consumer once the page channel is closed.
+	producerReason := TerminationCompleted
 
 	// Start goroutine for downloading Twitter feed in the background.
 	go func() {
+		defer t.workers.Done()
+		defer close(producerDone)
+
 		// Helper function that writes out the page to consumer or bails out
 		// if it detects that the consumer side has been shut down.
-		send := func(page FeedPageReader, err error) bool {
+		send := func(page FeedPageReader, err error, position string) bool {
 			select {
-			case pageChan <- pageIter{page, err}:
+			case pageChan <- pageIter{page, err, position}:
 				return true
 			case <-pageOut:
 				return false
+			case <-t.closed:
+				return false
+			case <-config.done:
+				return false
 			}
 		}
 
+		// Closed once the iteration is stopped, so that cursors waiting
+		// between requests can give up early.
+		stopped := make(chan struct{})
+		go func() {
+			select {
+			case <-pageOut:
+			case <-t.closed:
+			case <-config.done:
+			case <-producerDone:
+				return
+			}
+			close(stopped)
+		}()
+
+		var deadline <-chan time.Time
+		if config.maxDuration > 0 {
+			timer := time.NewTimer(config.maxDuration)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		var position string
+		logger := t.feedLogger()
+		pages := 0
+		shellPages := 0
+		// Positions retrieved so far, used to detect pagination loops.
+		visited := make(map[string]bool)
 		defer close(pageChan)
+		defer func() {
+			if r := recover(); r != nil {
+				send(nil, fmt.Errorf("Feed iterator panicked while retrieving page: %v", r), position)
+			}
+		}()
 		for {
-			page, err := t.cursor.RetrievePage()
-			if !send(page, err) || err != nil || onlyOnePage {
+			position = t.cursor.Position()
+			visited[position] = true
+			fetchStart := time.Now()
+			page, err := retrievePage(t.cursor, stopped)
+			pageLogger := logger.WithFields(log.Fields{
+				"position": position,
+				"page":     pages + 1,
+				"duration": time.Since(fetchStart),
+			})
+			// Errors are returned to the caller, so they're logged only for
+			// debugging.
+			if err != nil {
+				pageLogger.WithField("error", err.Error()).Debug("Failed to retrieve page")
+			} else {
+				if sized, ok := page.(interface{ Size() int }); ok {
+					pageLogger = pageLogger.WithField("bytes", sized.Size())
+				}
+				pageLogger.Debug("Fetched page")
+			}
+			if err == nil {
+				atomic.AddInt64(&t.pagesFetched, 1)
+				for _, callback := range config.onPage {
+					if err = callback(page, position); err != nil {
+						page = nil
+						break
+					}
+				}
+			}
+
+			// Placeholder pages have no tweets, which would end the iteration,
+			// so they're kept from the consumer until it's clear whether
+			// Twitter serves nothing but them.
+			if err == nil && !config.singlePage && isShellPage(page) {
+				shellPages++
+				if shellPages >= maxShellPages {
+					send(nil, &BlockedError{position, fmt.Sprintf(
+						"%d consecutive pages claim to have more tweets, but have none", shellPages)}, position)
+					return
+				}
+			} else {
+				shellPages = 0
+				if !send(page, err, position) || err != nil {
+					return
+				}
+			}
+			pages++
+			if config.singlePage {
+				producerReason = TerminationLimitReached
+				return
+			}
+
+			// Twitter's own flag is authoritative. Pages without it are
+			// followed until an empty page is retrieved.
+			if hasMore, err := page.HasMore(); err == nil && !hasMore {
 				return
 			}
 
 			if minPosition, err := page.GetMinPosition(); err == nil {
+				if len(position) > 0 && minPosition == position {
+					send(nil, &BlockedError{position, "page position didn't advance"}, position)
+					return
+				}
+				if len(minPosition) > 0 && visited[minPosition] {
+					send(nil, &PaginationLoopError{minPosition}, position)
+					return
+				}
 				if !t.cursor.Seek(minPosition) {
 					return
 				}
+				if config.maxPages > 0 && pages >= config.maxPages {
+					producerReason = TerminationLimitReached
+					return
+				}
+				select {
+				case <-deadline:
+					producerReason = TerminationLimitReached
+					return
+				default:
+				}
+				delay := t.nextPageDelay()
+				if config.waitRateLimit {
+					if wait := rateLimitWait(page); wait > delay {
+						t.logger.WithField("wait", wait).Info("Waiting for rate limit to reset")
+						delay = wait
+					}
+				}
+				if delay > 0 {
+					select {
+					case <-time.After(delay):
+					case <-pageOut:
+						return
+					case <-t.closed:
+						return
+					case <-config.done:
+						return
+					case <-deadline:
+						producerReason = TerminationLimitReached
+						return
+					}
+				}
 				continue
 			} else {
-				send(nil, err)
+				send(nil, err, position)
 				return
 			}
 		}
@@ -66,35 +479,332 @@ func (t *TwitterSession) FeedIter(singlePage ...bool) <-chan (FeedIterResult) {
 	// Consume pages produced by the above goroutine by parsing them and
 	// sending the individual tweets into the user channel.
 	go func() {
-		defer close(pageOut)
+		var position string
+		logger := t.feedLogger()
+		reason := TerminationCancelled
+		// Position of the last emitted tweet. The cursor is moved back to it
+		// if the iteration stops in the middle of a page.
+		var lastEmitted string
+		rewind := false
+		var stopProducer sync.Once
+		defer t.workers.Done()
+		defer stopProducer.Do(func() { close(pageOut) })
 		defer close(tweetChan)
-		for result := range pageChan {
+		defer func() {
+			t.setTermination(reason)
+		}()
+		defer func() {
+			if rewind {
+				// The producer may have moved the cursor past the page, so it
+				// has to stop before the cursor can be rewound.
+				stopProducer.Do(func() { close(pageOut) })
+				<-producerDone
+				rewindCursor(t.cursor, lastEmitted)
+			}
+		}()
+
+		// Helper function that writes out the result to the user or bails
+		// out if the session has been closed or the iteration cancelled.
+		emit := func(result FeedIterResult) bool {
+			// Results still fit into the channel's buffer after the consumer
+			// has cancelled, so cancellation is checked first.
+			select {
+			case <-config.done:
+				return false
+			default:
+			}
+			select {
+			case tweetChan <- result:
+				if result.Tweet != nil {
+					lastEmitted = result.Position
+				}
+				return true
+			case <-t.closed:
+				return false
+			case <-config.done:
+				return false
+			}
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				reason = TerminationError
+				emit(FeedIterResult{
+					Error:    fmt.Errorf("Feed iterator panicked while processing page: %v", r),
+					Position: position,
+				})
+			}
+		}()
+		buffer := reorderBuffer{window: config.reorderWindow}
+		flush := func() bool {
+			for _, result := range buffer.flush() {
+				if !emit(result) {
+					return false
+				}
+			}
+			return true
+		}
+		// Helper function that ends the iteration with an error.
+		fail := func(err error, position string) {
+			if flush() && emit(FeedIterResult{Error: err, Position: position}) {
+				reason = TerminationError
+			}
+		}
+		firstPage := true
+		emitted := 0
+		processed := 0
+		var lastTimestamp time.Time
+		for {
+			var result pageIter
+			var ok bool
+			select {
+			case result, ok = <-pageChan:
+			case <-t.closed:
+				return
+			case <-config.done:
+				return
+			}
+			if !ok {
+				break
+			}
+
+			position = result.position
 			if result.err != nil {
-				tweetChan <- FeedIterResult{nil, result.err}
+				fail(result.err, result.position)
 				return
 			}
-			tweets, err := result.page.GetTweets()
-			if err != nil {
-				tweetChan <- FeedIterResult{nil, err}
+
+			// Tweets are pushed as they are parsed, so the page position is
+			// recorded upfront.
+			buffer.nextPage(result.position)
+			pageTweets := 0
+			stopped := false
+			err := eachPageTweet(result.page, func(tweet *Tweet) error {
+				pageTweets++
+				if !config.disableDedupe {
+					if t.seenTweets.Has(tweet.ID) {
+						logger.WithFields(log.Fields{
+							"tweet-id":   tweet.ID,
+							"tweet-date": tweet.Timestamp,
+						}).Debugf("Duplicate tweet")
+						atomic.AddInt64(&t.duplicatesDropped, 1)
+						return nil
+					}
+					t.seenTweets.Add(tweet.ID)
+				}
+				if !config.accepts(tweet) {
+					return nil
+				}
+				atomic.AddInt64(&t.tweetsEmitted, 1)
+				emitted++
+				lastTimestamp = tweet.Timestamp
+				for _, ready := range buffer.push(tweet) {
+					if !emit(ready) {
+						stopped = true
+						return errIterStopped
+					}
+				}
+				if config.maxTweets > 0 && emitted >= config.maxTweets {
+					if flush() {
+						reason = TerminationLimitReached
+						rewind = true
+					}
+					stopped = true
+					return errIterStopped
+				}
+				return nil
+			})
+			if stopped {
 				return
 			}
-			if len(tweets) == 0 {
+			if err != nil {
+				fail(err, result.position)
 				return
 			}
-			for _, tweet := range tweets {
-				// XXX: No duplicate tweets has been encountered out there. Is it
-				// really neccessary to check tweet IDs against hash table?
-				if _, seenAlready := t.seenTweets[tweet.ID]; !seenAlready {
-					tweetChan <- FeedIterResult{tweet, nil}
-					t.seenTweets[tweet.ID] = struct{}{}
-				} else {
-					log.WithFields(log.Fields{
-						"tweet-id":   tweet.ID,
-						"tweet-date": tweet.Timestamp,
-					}).Debugf("Duplicate tweet")
+			if pageTweets == 0 {
+				if firstPage {
+					fail(emptyPageError(result.page), result.position)
+				} else if flush() {
+					reason = TerminationCompleted
 				}
+				return
+			}
+			firstPage = false
+
+			processed++
+			logger.WithFields(log.Fields{
+				"position": result.position,
+				"page":     processed,
+				"tweets":   pageTweets,
+				"emitted":  emitted,
+			}).Debug("Processed page")
+			if config.onProgress != nil && config.progressEvery > 0 &&
+				processed%config.progressEvery == 0 {
+				config.onProgress(Progress{
+					PagesFetched:  processed,
+					TweetsEmitted: emitted,
+					Timestamp:     lastTimestamp,
+					Elapsed:       time.Since(start),
+				})
 			}
 		}
+		if flush() {
+			reason = producerReason
+		}
 	}()
 	return tweetChan
 }
+
+// retrievePage retrieves the next page of the cursor. Cursors that wait
+// between requests stop waiting once done is closed.
+func retrievePage(cursor FeedCursor, done <-chan struct{}) (FeedPageReader, error) {
+	if interruptible, ok := cursor.(interface {
+		retrievePageUntil(<-chan struct{}) (FeedPageReader, error)
+	}); ok {
+		return interruptible.retrievePageUntil(done)
+	}
+	return cursor.RetrievePage()
+}
+
+// rewindCursor moves the cursor back to position of an earlier page. An empty
+// position means the beginning of the feed, which Seek() doesn't accept, so
+// cursors that support it are rewound explicitly.
+func rewindCursor(cursor FeedCursor, position string) bool {
+	if len(position) == 0 {
+		if rewinder, ok := cursor.(interface{ rewind() }); ok {
+			rewinder.rewind()
+			return true
+		}
+		return false
+	}
+	return cursor.Seek(position)
+}
+
+// errIterStopped is returned from tweet callbacks to stop processing of a
+// page once the iterator is done.
+var errIterStopped = errors.New("Iteration stopped")
+
+// eachPageTweet calls the function for every tweet of the page. Pages that
+// support it are parsed incrementally, so the first tweets can be emitted
+// before the rest of the page is parsed.
+func eachPageTweet(page FeedPageReader, callback func(*Tweet) error) error {
+	if streamed, ok := page.(interface {
+		EachTweet(func(*Tweet) error) error
+	}); ok {
+		return streamed.EachTweet(callback)
+	}
+
+	tweets, err := page.GetTweets()
+	if err != nil {
+		return err
+	}
+	for _, tweet := range tweets {
+		if err = callback(tweet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rateLimitWait returns how long to wait before the next request if the page
+// reports that the rate limit is exhausted.
+func rateLimitWait(page FeedPageReader) time.Duration {
+	limited, ok := page.(interface {
+		RateLimit() (int, time.Time, bool)
+	})
+	if !ok {
+		return 0
+	}
+	if remaining, reset, ok := limited.RateLimit(); ok && remaining <= 0 {
+		return time.Until(reset)
+	}
+	return 0
+}
+
+// reorderBuffer holds tweets that are waiting to be emitted in timestamp
+// order. A zero window disables reordering.
+type reorderBuffer struct {
+	window    int
+	tweets    []*Tweet
+	pages     []int
+	positions []string
+}
+
+// nextPage records position of the page whose tweets are pushed next.
+func (b *reorderBuffer) nextPage(position string) {
+	b.positions = append(b.positions, position)
+}
+
+// push adds a tweet of the current page and returns results that are ready to
+// be emitted.
+func (b *reorderBuffer) push(tweet *Tweet) []FeedIterResult {
+	b.tweets = append(b.tweets, tweet)
+	b.pages = append(b.pages, len(b.positions)-1)
+
+	var results []FeedIterResult
+	for len(b.tweets) > b.window {
+		results = append(results, b.pop())
+	}
+	return results
+}
+
+// flush returns all buffered tweets.
+func (b *reorderBuffer) flush() []FeedIterResult {
+	var results []FeedIterResult
+	for len(b.tweets) > 0 {
+		results = append(results, b.pop())
+	}
+	return results
+}
+
+// pop removes the newest buffered tweet. Without a window the tweets are
+// removed in the order they were pushed.
+func (b *reorderBuffer) pop() FeedIterResult {
+	index := 0
+	if b.window > 0 {
+		for i, tweet := range b.tweets {
+			if tweetIsNewer(tweet, b.tweets[index]) {
+				index = i
+			}
+		}
+	}
+
+	// Page indexes grow in retrieval order, so the oldest page has the
+	// smallest index.
+	oldestPage := b.pages[index]
+	for _, page := range b.pages {
+		if page < oldestPage {
+			oldestPage = page
+		}
+	}
+
+	tweet := b.tweets[index]
+	b.tweets = append(b.tweets[:index], b.tweets[index+1:]...)
+	b.pages = append(b.pages[:index], b.pages[index+1:]...)
+	return FeedIterResult{Tweet: tweet, Position: b.positions[oldestPage]}
+}
+
+// maxShellPages is the number of consecutive placeholder pages after which
+// FeedIter() gives up with *BlockedError.
+const maxShellPages = 3
+
+// isShellPage reports whether the page is a placeholder Twitter serves when
+// it soft-blocks a scraper: a page without content that claims there are more
+// tweets to retrieve.
+func isShellPage(page FeedPageReader) bool {
+	blankPage, ok := page.(interface{ IsBlank() bool })
+	if !ok || !blankPage.IsBlank() {
+		return false
+	}
+	hasMore, err := page.HasMore()
+	return err == nil && hasMore
+}
+
+// emptyPageError returns an error describing why the first page of a feed
+// contains no tweets.
+func emptyPageError(page FeedPageReader) error {
+	if blankPage, ok := page.(interface{ IsBlank() bool }); ok && !blankPage.IsBlank() {
+		return &APICompatError{msg: "Page has content, but no tweets were found"}
+	}
+	return ErrEmptyFeed
+}
@@ -0,0 +1,68 @@
+package rattler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// maxJSONLRecordSize is the maximum length of a single line accepted by
+// ReadTweetsJSONL().
+const maxJSONLRecordSize = 1 << 20
+
+// TweetJSONLWriter writes tweets as JSON Lines, i.e. one JSON object per line.
+//
+// Every record is flushed as soon as it's written if the underlying writer
+// supports flushing (e.g. *gzip.Writer or *bufio.Writer), so an interrupted
+// scrape leaves a readable file behind.
+type TweetJSONLWriter struct {
+	out io.Writer
+}
+
+// NewTweetJSONLWriter creates a JSON Lines writer that writes into w.
+func NewTweetJSONLWriter(w io.Writer) *TweetJSONLWriter {
+	return &TweetJSONLWriter{out: w}
+}
+
+// Write writes a single tweet record.
+func (t *TweetJSONLWriter) Write(tweet *Tweet) error {
+	data, err := json.Marshal(tweet)
+	if err != nil {
+		return err
+	}
+	if _, err = t.out.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if flusher, ok := t.out.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// Close closes the underlying writer, if it implements io.Closer.
+func (t *TweetJSONLWriter) Close() error {
+	if closer, ok := t.out.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ReadTweetsJSONL reads tweets written by TweetJSONLWriter. Blank lines are
+// skipped.
+func ReadTweetsJSONL(r io.Reader) ([]*Tweet, error) {
+	var tweets []*Tweet
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxJSONLRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		tweet := &Tweet{}
+		if err := json.Unmarshal(scanner.Bytes(), tweet); err != nil {
+			return tweets, fmt.Errorf("Malformed tweet on line %d: %s", line, err.Error())
+		}
+		tweets = append(tweets, tweet)
+	}
+	return tweets, scanner.Err()
+}
@@ -0,0 +1,51 @@
+package rattler
+
+import "container/list"
+
+// tweetIDLRU is a fixed-capacity set of tweet IDs. When the set is full, the
+// least recently used ID is evicted to make room for a new one.
+type tweetIDLRU struct {
+	capacity int
+	order    *list.List
+	elements map[uint64]*list.Element
+}
+
+func newTweetIDLRU(capacity int) *tweetIDLRU {
+	if capacity <= 0 {
+		panic("LRU capacity must be positive")
+	}
+	return &tweetIDLRU{
+		capacity: capacity,
+		order:    list.New(),
+		elements: make(map[uint64]*list.Element),
+	}
+}
+
+// Has reports whether the ID is in the set and marks it as recently used.
+func (t *tweetIDLRU) Has(id uint64) bool {
+	if elem, exists := t.elements[id]; exists {
+		t.order.MoveToFront(elem)
+		return true
+	}
+	return false
+}
+
+// Add inserts the ID into the set, evicting the least recently used ID if the
+// set is at capacity.
+func (t *tweetIDLRU) Add(id uint64) {
+	if elem, exists := t.elements[id]; exists {
+		t.order.MoveToFront(elem)
+		return
+	}
+	if t.order.Len() >= t.capacity {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.elements, oldest.Value.(uint64))
+	}
+	t.elements[id] = t.order.PushFront(id)
+}
+
+// Len returns the number of IDs in the set.
+func (t *tweetIDLRU) Len() int {
+	return t.order.Len()
+}
@@ -0,0 +1,29 @@
+package rattler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTweetIDLRUEviction(t *testing.T) {
+	lru := newTweetIDLRU(2)
+	lru.Add(1)
+	lru.Add(2)
+
+	// Touch 1, so that 2 becomes the least recently used ID.
+	assert.True(t, lru.Has(1))
+	lru.Add(3)
+
+	assert.Equal(t, 2, lru.Len())
+	assert.True(t, lru.Has(1))
+	assert.False(t, lru.Has(2))
+	assert.True(t, lru.Has(3))
+}
+
+func TestDedupeCapacityNonPositive(t *testing.T) {
+	for _, capacity := range []int{0, -1} {
+		session := NewTwitterSession(nil, WithDedupeCapacity(capacity))
+		assert.Equal(t, DefaultDedupeCapacity, session.seenTweets.(*tweetIDLRU).capacity)
+	}
+}
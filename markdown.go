@@ -0,0 +1,127 @@
+package rattler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// markdownEscaper escapes characters that have special meaning in Markdown
+// text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`<`, `\<`,
+	`>`, `\>`,
+	`#`, `\#`,
+)
+
+// RenderMarkdown formats a tweet as a Markdown blockquote.
+//
+// The quote contains tweet's text followed by its embedded object: gallery
+// images become image links, quoted tweet becomes a nested blockquote with
+// the quoted author, text and URL, cards, videos and GIFs become links and
+// poll options become a list. The last line attributes the tweet to its
+// author and links to the tweet's status page.
+func RenderMarkdown(tweet *Tweet) string {
+	var blocks []string
+	if text := markdownText(tweet.Text); len(text) > 0 {
+		blocks = append(blocks, text)
+	}
+	if embed := renderMarkdownEmbed(tweet.Extra); len(embed) > 0 {
+		blocks = append(blocks, embed)
+	}
+
+	author := "Tweet"
+	if len(tweet.Username) > 0 {
+		author = "@" + markdownEscaper.Replace(tweet.Username)
+	}
+	attribution := fmt.Sprintf("— [%s](%s)", author, tweet.PermalinkURL())
+	if !tweet.Timestamp.IsZero() {
+		attribution += ", " + tweet.Timestamp.UTC().Format("2006-01-02 15:04 UTC")
+	}
+	blocks = append(blocks, attribution)
+
+	return markdownQuote(strings.Join(blocks, "\n\n")) + "\n"
+}
+
+// renderMarkdownEmbed formats an embedded object as a Markdown block or
+// returns an empty string if there's nothing to render.
+func renderMarkdownEmbed(extra interface{}) string {
+	switch e := extra.(type) {
+	case *TweetEmbeddedGallery:
+		images := make([]string, 0, len(e.ImageURLs))
+		for i, url := range e.ImageURLs {
+			alt := "Image " + strconv.Itoa(i+1)
+			if i < len(e.AltTexts) && len(e.AltTexts[i]) > 0 {
+				alt = markdownEscaper.Replace(strings.Join(strings.Fields(e.AltTexts[i]), " "))
+			}
+			images = append(images, fmt.Sprintf("![%s](%s)", alt, url))
+		}
+		return strings.Join(images, "\n")
+	case *TweetEmbeddedVideo:
+		return fmt.Sprintf("[Video](%s)", e.VideoURL)
+	case *TweetEmbeddedGIF:
+		if len(e.ThumbnailURL) > 0 {
+			return fmt.Sprintf("[![GIF](%s)](%s)", e.ThumbnailURL, e.VideoURL)
+		}
+		return fmt.Sprintf("[GIF](%s)", e.VideoURL)
+	case *TweetEmbeddedCard:
+		card := fmt.Sprintf("[%s](%s)", markdownEscaper.Replace(e.CardURL), e.CardURL)
+		if len(e.ImageURL) > 0 {
+			card += "\n\n" + fmt.Sprintf("![Card image](%s)", e.ImageURL)
+		}
+		return card
+	case *TweetEmbeddedQuote:
+		var quote []string
+		if len(e.QuotedDisplayName) > 0 {
+			quote = append(quote, "**"+markdownEscaper.Replace(e.QuotedDisplayName)+"**")
+		}
+		if text := markdownText(e.QuotedText); len(text) > 0 {
+			quote = append(quote, text)
+		}
+		quote = append(quote, fmt.Sprintf("[Quoted tweet](%s)", e.QuoteURL))
+		return markdownQuote(strings.Join(quote, "\n\n"))
+	case *TweetEmbeddedPoll:
+		options := make([]string, 0, len(e.Options))
+		for _, option := range e.Options {
+			options = append(options, fmt.Sprintf(
+				"- %s: %s%%",
+				markdownEscaper.Replace(option.Label),
+				strconv.FormatFloat(option.Percent, 'f', -1, 64)))
+		}
+		return strings.Join(options, "\n")
+	}
+	return ""
+}
+
+// markdownText escapes text and keeps its line breaks.
+func markdownText(text string) string {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = markdownEscaper.Replace(line)
+	}
+	// Trailing backslash forces a line break without adding a paragraph.
+	return strings.Join(lines, "\\\n")
+}
+
+// markdownQuote prefixes every line of text with a blockquote marker.
+func markdownQuote(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if len(line) == 0 {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
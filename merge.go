@@ -0,0 +1,98 @@
+package rattler
+
+import "context"
+
+// MergeFeeds iterates feeds of the sessions at once and merges them into a
+// single stream ordered by tweet timestamp (newest first). Tweets with the same
+// ID are emitted only once, even if they come from different sessions.
+//
+// Every session is iterated with FeedIter(). The merge keeps one tweet per
+// session buffered, and emits a tweet only once every session that hasn't
+// finished yet has a tweet buffered, so the order is exact as long as each
+// individual feed is chronological.
+//
+// Errors are forwarded as soon as they are received and don't stop iteration
+// of the other sessions. Position of a result is the position within the feed
+// of the session the result came from.
+//
+// The returned channel is closed once every session's iteration is over.
+// Cancelling ctx or closing any of the sessions stops the merge along with
+// the iterators of all sessions, whose TerminationReason() then becomes
+// TerminationCancelled. A consumer that stops receiving early should cancel
+// ctx.
+func MergeFeeds(ctx context.Context, sessions ...*TwitterSession) <-chan FeedIterResult {
+	ctx, cancel := context.WithCancel(ctx)
+	resultChan := make(chan FeedIterResult, 5)
+
+	// Stop the merge once any of the sessions gets closed.
+	for _, session := range sessions {
+		go func(session *TwitterSession) {
+			select {
+			case <-session.closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}(session)
+	}
+
+	emit := func(result FeedIterResult) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		select {
+		case resultChan <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	sources := make([]<-chan FeedIterResult, len(sessions))
+	for i, session := range sessions {
+		sources[i] = session.FeedIter(Context(ctx))
+	}
+
+	go func() {
+		defer close(resultChan)
+		// Stops iterators of the remaining sessions.
+		defer cancel()
+		seen := newTweetIDLRU(DefaultDedupeCapacity)
+		heads := make([]*FeedIterResult, len(sources))
+		for {
+			for i := range sources {
+				for sources[i] != nil && heads[i] == nil {
+					result, ok := <-sources[i]
+					if !ok {
+						sources[i] = nil
+					} else if result.Error != nil {
+						if !emit(result) {
+							return
+						}
+					} else {
+						heads[i] = &result
+					}
+				}
+			}
+
+			newest := -1
+			for i, head := range heads {
+				if head != nil && (newest < 0 || tweetIsNewer(head.Tweet, heads[newest].Tweet)) {
+					newest = i
+				}
+			}
+			if newest < 0 {
+				return
+			}
+			result := *heads[newest]
+			heads[newest] = nil
+			if seen.Has(result.Tweet.ID) {
+				continue
+			}
+			seen.Add(result.Tweet.ID)
+			if !emit(result) {
+				return
+			}
+		}
+	}()
+	return resultChan
+}
@@ -0,0 +1,86 @@
+package rattler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeFeeds(t *testing.T) {
+	first, firstServer := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer firstServer.Close()
+	second, secondServer := setupFixtureSession(t, "testdata/items3.json", "testdata/items4.json")
+	defer secondServer.Close()
+	third, thirdServer := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer thirdServer.Close()
+
+	failing := NewTwitterSession(NewGenericFeedCursor("test", FeedTypeMedia))
+	client, failingServer := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer failingServer.Close()
+	failing.cursor.(*GenericFeedCursor).client.httpClient = client
+
+	var tweets []*Tweet
+	var errs []error
+	for result := range MergeFeeds(context.Background(), first, failing, second, third) {
+		if result.Error != nil {
+			errs = append(errs, result.Error)
+			continue
+		}
+		tweets = append(tweets, result.Tweet)
+	}
+
+	assert.Equal(t, 1, len(errs))
+	require.Equal(t, 39, len(tweets))
+	seen := map[uint64]bool{}
+	for i, tweet := range tweets {
+		assert.False(t, seen[tweet.ID], "Tweet %d is emitted twice", tweet.ID)
+		seen[tweet.ID] = true
+		if i > 0 {
+			assert.False(t, tweetIsNewer(tweet, tweets[i-1]),
+				"Tweet %d is newer than its predecessor", tweet.ID)
+		}
+	}
+}
+
+func TestMergeFeedsClose(t *testing.T) {
+	first, firstServer := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer firstServer.Close()
+	second, secondServer := setupFixtureSession(t, "testdata/items3.json", "testdata/items4.json")
+	defer secondServer.Close()
+
+	results := MergeFeeds(context.Background(), first, second)
+	<-results
+	second.Close()
+	for range results {
+	}
+	// Iterator of the session that's still open is stopped as well.
+	assert.Eventually(t, func() bool {
+		return first.TerminationReason() == TerminationCancelled
+	}, 5*time.Second, 10*time.Millisecond)
+	first.Close()
+}
+
+func TestMergeFeedsCancel(t *testing.T) {
+	first, firstServer := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer firstServer.Close()
+	second, secondServer := setupFixtureSession(t, "testdata/items3.json", "testdata/items4.json")
+	defer secondServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := MergeFeeds(ctx, first, second)
+	<-results
+	cancel()
+	for range results {
+	}
+	for _, session := range []*TwitterSession{first, second} {
+		assert.Eventually(t, func() bool {
+			return session.TerminationReason() == TerminationCancelled
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
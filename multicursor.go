@@ -0,0 +1,370 @@
+package rattler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// multiUserPageSize is the maximum number of tweets returned in a single
+// merged page.
+const multiUserPageSize = 20
+
+// MultiUserFeedCursor traverses feeds of several users at once, merging them
+// into a single feed ordered by tweet timestamp (newest first).
+//
+// The cursor keeps one page of look-ahead per user. A tweet is emitted only
+// when every user with tweets left has a page buffered, so the merged order is
+// exact as long as each individual feed is chronological.
+type MultiUserFeedCursor struct {
+	users []*multiUserFeed
+
+	accountDelay  time.Duration
+	accountJitter time.Duration
+	// User whose page was requested last, used to tell when the cursor
+	// moves on to another account.
+	lastRequested *multiUserFeed
+}
+
+// multiUserFeed holds position of a single user's feed within
+// MultiUserFeedCursor along with the last retrieved page of that feed.
+type multiUserFeed struct {
+	cursor   *GenericFeedCursor
+	username string
+
+	// Position of the page being consumed and the number of tweets consumed
+	// from that page.
+	anchor    string
+	offset    int
+	exhausted bool
+
+	// Page cache, so that retrieving the same position twice doesn't
+	// generate additional requests.
+	cached       bool
+	cachedAnchor string
+	cachedTweets []*Tweet
+	cachedNext   string
+}
+
+// mergedFeedPage is a page produced by MultiUserFeedCursor.
+type mergedFeedPage struct {
+	tweets      []*Tweet
+	minPosition string
+}
+
+// NewMultiUserFeedCursor creates a cursor for traversing merged feeds of the
+// given users.
+//
+// A malformed resume position is logged and the cursor starts from the top of
+// the feeds. Use NewMultiUserFeedCursorAt to handle it as an error.
+func NewMultiUserFeedCursor(
+	usernames []string,
+	ttype FeedFilter, resumeAt ...string,
+) *MultiUserFeedCursor {
+	if len(resumeAt) > 1 {
+		panic("Too many arguments")
+	}
+	cursor := newMultiUserFeedCursor(usernames, ttype)
+	if len(resumeAt) == 1 && !cursor.Seek(resumeAt[0]) && len(resumeAt[0]) > 0 {
+		log.WithField("position", resumeAt[0]).Warn(
+			"Malformed resume position, starting from the top")
+	}
+	return cursor
+}
+
+// NewMultiUserFeedCursorAt creates a cursor for traversing merged feeds of the
+// given users, which is positioned at resumeAt. An empty position means the
+// top of the feeds.
+func NewMultiUserFeedCursorAt(
+	usernames []string,
+	ttype FeedFilter, resumeAt string,
+) (*MultiUserFeedCursor, error) {
+	cursor := newMultiUserFeedCursor(usernames, ttype)
+	if !cursor.Seek(resumeAt) && len(resumeAt) > 0 {
+		return nil, fmt.Errorf("Malformed resume position '%s'", resumeAt)
+	}
+	return cursor, nil
+}
+
+func newMultiUserFeedCursor(usernames []string, ttype FeedFilter) *MultiUserFeedCursor {
+	client := NewTwitterHTTP()
+	cursor := &MultiUserFeedCursor{}
+	for _, username := range usernames {
+		userCursor := NewGenericFeedCursor(username, ttype)
+		userCursor.client = client
+		cursor.users = append(cursor.users, &multiUserFeed{
+			cursor:   userCursor,
+			username: username,
+		})
+	}
+	return cursor
+}
+
+// SetAccountDelay makes the cursor pause for the given delay plus a random
+// duration of up to jitter before requesting a page of a different user than
+// the one requested last. Pages served from the cursor's look-ahead don't
+// cause a pause.
+//
+// The pause happens within RetrievePage() and adds to the delay set by
+// WithPageDelay(). When the cursor is driven by FeedIter(), the pause ends
+// early once the iteration is stopped. Zero delay and jitter, which is the
+// default, disable it.
+func (t *MultiUserFeedCursor) SetAccountDelay(delay, jitter time.Duration) {
+	t.accountDelay = delay
+	t.accountJitter = jitter
+}
+
+// SetClient makes the cursor use given client for all requests.
+func (t *MultiUserFeedCursor) SetClient(client *TwitterHTTP) {
+	for _, user := range t.users {
+		user.cursor.client = client
+	}
+}
+
+func (t *MultiUserFeedCursor) twitterHTTP() *TwitterHTTP {
+	if len(t.users) == 0 {
+		return nil
+	}
+	return t.users[0].cursor.client
+}
+
+// logFields returns log fields identifying the feed.
+func (t *MultiUserFeedCursor) logFields() log.Fields {
+	usernames := make([]string, len(t.users))
+	for i, user := range t.users {
+		usernames[i] = user.username
+	}
+	return log.Fields{"usernames": strings.Join(usernames, ","), "feed": "multi-user"}
+}
+
+// RetrievePage downloads pages of individual feeds that are necessary to
+// produce the next merged page.
+//
+// Does not advance the cursor.
+func (t *MultiUserFeedCursor) RetrievePage() (FeedPageReader, error) {
+	return t.retrievePageUntil(nil)
+}
+
+// retrievePageUntil is like RetrievePage(), but stops waiting between
+// accounts once done is closed.
+func (t *MultiUserFeedCursor) retrievePageUntil(done <-chan struct{}) (FeedPageReader, error) {
+	type mergeState struct {
+		anchor    string
+		offset    int
+		exhausted bool
+	}
+
+	states := make([]mergeState, len(t.users))
+	for i, user := range t.users {
+		states[i] = mergeState{user.anchor, user.offset, user.exhausted}
+	}
+
+	// Makes sure that the user has an unconsumed tweet buffered, moving on to
+	// the next page of the user's feed if necessary.
+	fill := func(i int) error {
+		state := &states[i]
+		for !state.exhausted {
+			user := t.users[i]
+			if !user.isCached(state.anchor) {
+				if t.lastRequested != nil && t.lastRequested != user {
+					timer := time.NewTimer(jitteredDelay(t.accountDelay, t.accountJitter))
+					select {
+					case <-timer.C:
+					case <-done:
+						timer.Stop()
+						return errIterStopped
+					}
+				}
+				t.lastRequested = user
+			}
+			tweets, next, err := user.fetch(state.anchor)
+			if err != nil {
+				return err
+			}
+			if state.offset < len(tweets) {
+				return nil
+			}
+			if len(tweets) == 0 || len(next) == 0 {
+				state.exhausted = true
+			} else {
+				state.anchor = next
+				state.offset = 0
+			}
+		}
+		return nil
+	}
+
+	var tweets []*Tweet
+	for len(tweets) < multiUserPageSize {
+		newest := -1
+		var newestTweet *Tweet
+		for i := range t.users {
+			if err := fill(i); err != nil {
+				return nil, err
+			}
+			if states[i].exhausted {
+				continue
+			}
+			tweet := t.users[i].cachedTweets[states[i].offset]
+			if newestTweet == nil || tweetIsNewer(tweet, newestTweet) {
+				newest, newestTweet = i, tweet
+			}
+		}
+		if newestTweet == nil {
+			break
+		}
+		tweets = append(tweets, newestTweet)
+		states[newest].offset++
+	}
+
+	page := &mergedFeedPage{tweets: tweets}
+	if len(tweets) > 0 {
+		values := make(url.Values)
+		for i, user := range t.users {
+			encodeUserPosition(values, user.username,
+				states[i].anchor, states[i].offset, states[i].exhausted)
+		}
+		page.minPosition = values.Encode()
+	}
+	return page, nil
+}
+
+// Seek positions cursor at given position within feed.
+func (t *MultiUserFeedCursor) Seek(position string) bool {
+	if len(position) == 0 {
+		return false
+	}
+	values, err := url.ParseQuery(position)
+	if err != nil {
+		return false
+	}
+
+	type userPosition struct {
+		anchor    string
+		offset    int
+		exhausted bool
+	}
+	positions := make([]userPosition, len(t.users))
+	for i, user := range t.users {
+		value := values.Get(user.username)
+		if value == "-" {
+			positions[i].exhausted = true
+			continue
+		}
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		offset, err := strconv.Atoi(parts[0])
+		if err != nil || offset < 0 {
+			return false
+		}
+		positions[i] = userPosition{anchor: parts[1], offset: offset}
+	}
+
+	for i, user := range t.users {
+		user.anchor = positions[i].anchor
+		user.offset = positions[i].offset
+		user.exhausted = positions[i].exhausted
+	}
+	return true
+}
+
+// rewind positions cursor at the beginning of the feeds.
+func (t *MultiUserFeedCursor) rewind() {
+	for _, user := range t.users {
+		user.anchor = ""
+		user.offset = 0
+		user.exhausted = false
+	}
+}
+
+// Position returns the current position within feed. An empty string means
+// the cursor points at the beginning of the feed.
+func (t *MultiUserFeedCursor) Position() string {
+	values := make(url.Values)
+	atBeginning := true
+	for _, user := range t.users {
+		encodeUserPosition(values, user.username, user.anchor, user.offset, user.exhausted)
+		if user.exhausted || user.offset > 0 || len(user.anchor) > 0 {
+			atBeginning = false
+		}
+	}
+	if atBeginning {
+		return ""
+	}
+	return values.Encode()
+}
+
+// encodeUserPosition stores position of a single user's feed in values.
+//
+// Position is encoded as "<offset>:<anchor>" or "-" if the feed has been
+// exhausted.
+func encodeUserPosition(values url.Values, username, anchor string, offset int, exhausted bool) {
+	if exhausted {
+		values.Set(username, "-")
+	} else {
+		values.Set(username, fmt.Sprintf("%d:%s", offset, anchor))
+	}
+}
+
+// fetch returns tweets of the page at given position and position of the
+// next page.
+func (t *multiUserFeed) fetch(anchor string) ([]*Tweet, string, error) {
+	if t.isCached(anchor) {
+		return t.cachedTweets, t.cachedNext, nil
+	}
+
+	t.cursor.nextPageAnchor = anchor
+	page, err := t.cursor.RetrievePage()
+	if err != nil {
+		return nil, "", err
+	}
+	tweets, err := page.GetTweets()
+	if err != nil {
+		return nil, "", err
+	}
+	next, err := page.GetMinPosition()
+	if err != nil {
+		return nil, "", err
+	}
+
+	t.cached = true
+	t.cachedAnchor = anchor
+	t.cachedTweets = tweets
+	t.cachedNext = next
+	return tweets, next, nil
+}
+
+// isCached reports whether the page at anchor is served without a request.
+func (t *multiUserFeed) isCached(anchor string) bool {
+	return t.cached && t.cachedAnchor == anchor
+}
+
+// GetTweets returns a list of tweets in page.
+func (t *mergedFeedPage) GetTweets() ([]*Tweet, error) {
+	return t.tweets, nil
+}
+
+// GetMinPosition returns a position of this page within feed.
+func (t *mergedFeedPage) GetMinPosition() (string, error) {
+	return t.minPosition, nil
+}
+
+// HasMore reports whether the merged feed may continue past this page. A page
+// that isn't full means that every individual feed has been exhausted.
+func (t *mergedFeedPage) HasMore() (bool, error) {
+	return len(t.tweets) == multiUserPageSize, nil
+}
+
+// tweetIsNewer reports whether tweet a should precede tweet b in a feed.
+func tweetIsNewer(a, b *Tweet) bool {
+	if a.Timestamp.Equal(b.Timestamp) {
+		return a.ID > b.ID
+	}
+	return a.Timestamp.After(b.Timestamp)
+}
@@ -0,0 +1,117 @@
+package rattler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiUserFeedCursor(t *testing.T) {
+	fixtures := map[string][]string{
+		"alice": {"testdata/items1.json", "testdata/items4.json"},
+		"bob":   {"testdata/items3.json", "testdata/items4.json"},
+	}
+	requests := 0
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			username := strings.Split(r.URL.Path, "/")[4]
+			_, hasPosition := r.URL.Query()["max_position"]
+			if hasPosition {
+				fmt.Fprint(w, readTextFileOrDie(fixtures[username][1]))
+			} else {
+				fmt.Fprint(w, readTextFileOrDie(fixtures[username][0]))
+			}
+		}))
+	defer server.Close()
+
+	cursor := NewMultiUserFeedCursor([]string{"alice", "bob"}, FeedTypeMedia)
+	cursor.SetClient(&TwitterHTTP{httpClient: client, userAgent: DefaultUserAgent})
+	cursor.SetAccountDelay(20*time.Millisecond, 0)
+	session := NewTwitterSession(cursor)
+
+	start := time.Now()
+	var tweets []*Tweet
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+		tweets = append(tweets, result.Tweet)
+	}
+	// The cursor switches between the users at least once.
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	assert.Equal(t, 39, len(tweets))
+	for i := 1; i < len(tweets); i++ {
+		assert.False(t, tweetIsNewer(tweets[i], tweets[i-1]),
+			"Tweet %d is newer than its predecessor", tweets[i].ID)
+	}
+	// Each user's page should be fetched exactly once.
+	assert.Equal(t, 4, requests)
+}
+
+func TestMultiUserFeedCursorAccountDelayCancel(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, readTextFileOrDie("testdata/items1.json"))
+		}))
+	defer server.Close()
+
+	newSession := func() *TwitterSession {
+		cursor := NewMultiUserFeedCursor([]string{"alice", "bob"}, FeedTypeMedia)
+		cursor.SetClient(&TwitterHTTP{httpClient: client, userAgent: DefaultUserAgent})
+		cursor.SetAccountDelay(time.Hour, 0)
+		return NewTwitterSession(cursor)
+	}
+
+	// The first page needs both users, so the iterator waits before
+	// requesting the second one until it's cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	session := newSession()
+	for range session.FeedIter(Context(ctx)) {
+	}
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, TerminationCancelled, session.TerminationReason())
+
+	session.Close()
+
+	// Closing the session stops the wait as well.
+	session = newSession()
+	tweets := session.FeedIter()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		session.Close()
+	}()
+	for range tweets {
+	}
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestMultiUserFeedCursorSeek(t *testing.T) {
+	cursor := NewMultiUserFeedCursor([]string{"alice", "bob"}, FeedTypeRegular)
+	assert.Equal(t, "", cursor.Position())
+
+	assert.True(t, cursor.Seek("alice=3%3A100&bob=-"))
+	assert.Equal(t, "alice=3%3A100&bob=-", cursor.Position())
+
+	resumed := NewMultiUserFeedCursor([]string{"alice", "bob"}, FeedTypeRegular, cursor.Position())
+	assert.Equal(t, cursor.Position(), resumed.Position())
+
+	assert.False(t, cursor.Seek("alice=bogus"))
+	assert.Equal(t, "alice=3%3A100&bob=-", cursor.Position())
+
+	// Malformed resume positions start from the top or fail explicitly.
+	resumed = NewMultiUserFeedCursor([]string{"alice", "bob"}, FeedTypeRegular, "alice=bogus")
+	assert.Equal(t, "", resumed.Position())
+	_, err := NewMultiUserFeedCursorAt([]string{"alice", "bob"}, FeedTypeRegular, "alice=bogus")
+	assert.NotNil(t, err)
+	resumed, err = NewMultiUserFeedCursorAt([]string{"alice", "bob"}, FeedTypeRegular, cursor.Position())
+	require.Nil(t, err)
+	assert.Equal(t, cursor.Position(), resumed.Position())
+}
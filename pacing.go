@@ -0,0 +1,147 @@
+package rattler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AdaptivePacing configures delays that TwitterHTTP inserts between requests
+// in order to adapt to Twitter's rate limiting.
+//
+// Requests are spaced by BaseDelay at first. Each response with status 429
+// multiplies the delay by Backoff. If the server gives a longer Retry-After
+// duration, the next request is postponed accordingly, but the delay between
+// the following requests isn't affected. Each successful response moves the
+// delay back toward BaseDelay by keeping Decay fraction of the excess.
+//
+// Rate limited requests aren't retried automatically, the delay only applies
+// to the following requests. Waiting is interrupted when the request's
+// context is done.
+type AdaptivePacing struct {
+	BaseDelay time.Duration
+	// MaxDelay caps the delay grown by Backoff. Zero means
+	// DefaultMaxPacingDelay. Retry-After isn't capped, but only postpones a
+	// single request.
+	MaxDelay time.Duration
+	// Backoff is the multiplier applied on rate limiting. Values not greater
+	// than 1 mean 2.
+	Backoff float64
+	// Decay is the fraction of the delay above BaseDelay that's kept after a
+	// successful response. Values outside of (0, 1) mean 0.9.
+	Decay float64
+}
+
+// DefaultMaxPacingDelay is the maximum delay of AdaptivePacing, unless
+// overridden.
+const DefaultMaxPacingDelay = 5 * time.Minute
+
+// minPacingBackoffDelay is the delay rate limiting raises a zero delay to,
+// since multiplying zero wouldn't slow anything down.
+const minPacingBackoffDelay = time.Second
+
+// pacer spaces requests according to AdaptivePacing. It's safe for concurrent
+// use.
+type pacer struct {
+	config AdaptivePacing
+
+	mutex sync.Mutex
+	delay time.Duration
+	next  time.Time
+}
+
+// WithAdaptivePacing makes the client space its requests and slow down when
+// Twitter starts rate limiting them. See AdaptivePacing.
+func WithAdaptivePacing(pacing AdaptivePacing) HTTPOption {
+	return func(t *TwitterHTTP) {
+		if pacing.MaxDelay <= 0 {
+			pacing.MaxDelay = DefaultMaxPacingDelay
+		}
+		if pacing.Backoff <= 1 {
+			pacing.Backoff = 2
+		}
+		if pacing.Decay <= 0 || pacing.Decay >= 1 {
+			pacing.Decay = 0.9
+		}
+		t.pacer = &pacer{config: pacing, delay: pacing.BaseDelay}
+	}
+}
+
+// PacingDelay returns the current delay between requests or zero if the
+// client doesn't use adaptive pacing.
+func (t *TwitterHTTP) PacingDelay() time.Duration {
+	if t.pacer == nil {
+		return 0
+	}
+	t.pacer.mutex.Lock()
+	defer t.pacer.mutex.Unlock()
+	return t.pacer.delay
+}
+
+// wait blocks until the next request may be sent or the context is done, in
+// which case the context's error is returned.
+func (t *pacer) wait(ctx context.Context) error {
+	t.mutex.Lock()
+	now := time.Now()
+	start := t.next
+	if start.Before(now) {
+		start = now
+	}
+	t.next = start.Add(t.delay)
+	t.mutex.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// update adjusts the delay according to the response.
+func (t *pacer) update(response *http.Response) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+		delay := time.Duration(float64(t.delay) * t.config.Backoff)
+		if delay <= 0 {
+			delay = minPacingBackoffDelay
+		}
+		if delay > t.config.MaxDelay {
+			delay = t.config.MaxDelay
+		}
+		t.delay = delay
+		// Retry-After postpones only the next request, otherwise a single
+		// long pause would slow down all the following ones.
+		if retryAfter := parseRetryAfter(response.Header.Get("Retry-After")); retryAfter > delay {
+			delay = retryAfter
+		}
+		t.next = time.Now().Add(delay)
+	case http.StatusOK, http.StatusNotModified:
+		if t.delay > t.config.BaseDelay {
+			excess := float64(t.delay - t.config.BaseDelay)
+			t.delay = t.config.BaseDelay + time.Duration(excess*t.config.Decay)
+		}
+	}
+}
+
+// parseRetryAfter parses value of Retry-After header, which is either a number
+// of seconds or a date. Returns zero if the value can't be parsed.
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package rattler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptivePacing(t *testing.T) {
+	status := http.StatusOK
+	retryAfter := ""
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		if len(retryAfter) > 0 {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	})
+	defer server.Close()
+
+	twitterHTTP := NewTwitterHTTP(WithTransport(client.Transport), WithAdaptivePacing(AdaptivePacing{
+		BaseDelay: 10 * time.Millisecond,
+		MaxDelay:  40 * time.Millisecond,
+		Decay:     0.5,
+	}))
+	request := func() {
+		request, err := twitterHTTP.newRequestS("https://twitter.com/")
+		require.Nil(t, err)
+		twitterHTTP.httpRequest(request)
+	}
+
+	request()
+	assert.Equal(t, 10*time.Millisecond, twitterHTTP.PacingDelay())
+
+	// Rate limiting grows the delay up to the limit.
+	status = http.StatusTooManyRequests
+	request()
+	assert.Equal(t, 20*time.Millisecond, twitterHTTP.PacingDelay())
+	request()
+	request()
+	assert.Equal(t, 40*time.Millisecond, twitterHTTP.PacingDelay())
+
+	// Successful requests bring it back toward the base.
+	status = http.StatusOK
+	start := time.Now()
+	request()
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+	assert.Equal(t, 25*time.Millisecond, twitterHTTP.PacingDelay())
+
+	// Retry-After postpones the next request even above the limit, but
+	// doesn't change the delay.
+	status, retryAfter = http.StatusTooManyRequests, "1"
+	request()
+	assert.Equal(t, 40*time.Millisecond, twitterHTTP.PacingDelay())
+
+	// Waiting for the postponed request can be cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cancelled, err := twitterHTTP.newRequestS("https://twitter.com/")
+	require.Nil(t, err)
+	start = time.Now()
+	_, err = twitterHTTP.httpRequest(cancelled.WithContext(ctx))
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	assert.Equal(t, time.Duration(0), NewTwitterHTTP().PacingDelay())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	delay := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, delay > 50*time.Second && delay <= time.Minute, delay)
+}
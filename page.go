@@ -1,11 +1,17 @@
 package rattler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	gq "github.com/PuerkitoBio/goquery"
@@ -18,14 +24,48 @@ import (
 type FeedPageReader interface {
 	GetTweets() ([]*Tweet, error)
 	GetMinPosition() (string, error)
+
+	// HasMore reports whether the feed continues past this page. An error is
+	// returned if the page doesn't carry this information.
+	HasMore() (bool, error)
 }
 
 // FeedPage stores a single page from Twitter feed.
 //
 // Tweets and additional page data can be retrieved through FeedPage interface,
 // which is implemented by this type.
+//
+// Page's HTML is parsed once and the document is shared by GetTweets() and
+// GetMinPosition(), which may be called from different goroutines. Calling
+// GetTweets() concurrently isn't safe though.
 type FeedPage struct {
-	json map[string]interface{}
+	json    map[string]interface{}
+	skipped []error
+	logger  log.FieldLogger
+	size    int
+	header  http.Header
+
+	selectors *Selectors
+
+	docOnce sync.Once
+	doc     *gq.Document
+	docErr  error
+}
+
+// newerFeedPage is a page retrieved while traversing feed towards newer tweets.
+//
+// Its position is the newest edge of the page, which is where the traversal
+// continues from.
+type newerFeedPage struct {
+	*FeedPage
+}
+
+// directedPage returns page suitable for traversing feed in given direction.
+func directedPage(page *FeedPage, direction Direction) FeedPageReader {
+	if direction == DirectionNewer {
+		return &newerFeedPage{page}
+	}
+	return page
 }
 
 // NewFeedPage creates a page parser.
@@ -39,52 +79,248 @@ func NewFeedPage(structuredJSON interface{}) *FeedPage {
 	}
 }
 
+// NewFeedPageFromHTML creates a page from items HTML stored earlier, e.g. the
+// output of RawHTML(), allowing to parse it without network access.
+//
+// The page has no other attributes, so its position is taken from the HTML
+// and HasMore() reports an error.
+func NewFeedPageFromHTML(itemsHTML string) *FeedPage {
+	return &FeedPage{
+		json: map[string]interface{}{"items_html": itemsHTML},
+		size: len(itemsHTML),
+	}
+}
+
+// NewFeedPageFromJSON creates a page from a JSON response of a feed endpoint
+// stored earlier, e.g. with WithJSONTee(), allowing to parse it without network
+// access.
+func NewFeedPageFromJSON(data []byte) (*FeedPage, error) {
+	var structuredJSON interface{}
+	if err := json.Unmarshal(data, &structuredJSON); err != nil {
+		return nil, fmt.Errorf("Failed to decode feed page JSON: %w", err)
+	}
+	page := NewFeedPage(structuredJSON)
+	if page == nil {
+		return nil, errors.New("Feed page JSON is not an object")
+	}
+	page.size = len(data)
+	return page, nil
+}
+
 // GetTweets returns a list of tweets in page.
+//
+// Tweets that fail to parse are skipped rather than aborting the whole page.
+// Their number can be retrieved with SkippedCount(). An error is returned if
+// page's HTML can't be parsed or if the page contains tweets and none of them
+// could be parsed.
 func (t *FeedPage) GetTweets() ([]*Tweet, error) {
-	html, err := t.lookupString("items_html")
+	doc, err := t.document()
 	if err != nil {
 		return []*Tweet{}, err
 	}
-	return t.extractTweets(html)
+	return t.extractDocumentTweets(doc)
+}
+
+// EachTweet calls the function for every tweet of the page as soon as the
+// tweet is parsed, without building the list of tweets GetTweets() returns.
+// The iteration stops when the function returns an error, which is then
+// returned by EachTweet().
+//
+// Tweets that fail to parse are skipped the same way GetTweets() skips them.
+func (t *FeedPage) EachTweet(callback func(*Tweet) error) error {
+	doc, err := t.document()
+	if err != nil {
+		return err
+	}
+	return t.eachDocumentTweet(doc, callback)
+}
+
+// GetTweetsWithErrors is like GetTweets(), but instead of reporting only the
+// first failure it returns errors of every tweet that couldn't be parsed along
+// with the tweets that were parsed successfully.
+//
+// Useful for diagnosing markup changes, which usually affect the entire page.
+func (t *FeedPage) GetTweetsWithErrors() ([]*Tweet, []error) {
+	doc, err := t.document()
+	if err != nil {
+		return []*Tweet{}, []error{err}
+	}
+	tweets, err := t.extractDocumentTweets(doc)
+	if err != nil && len(t.skipped) == 0 {
+		return tweets, []error{err}
+	}
+	return tweets, t.SkippedErrors()
+}
+
+// GetMinPosition returns the position of the newest tweet of the page, or an
+// empty string if there are no newer tweets.
+func (t *newerFeedPage) GetMinPosition() (string, error) {
+	value, exists := t.json[newerPagePositionAttr]
+	if exists && value == nil {
+		return "", nil
+	}
+	if pos, ok := positionString(value); ok {
+		return pos, nil
+	}
+	return t.lookupString(newerPagePositionAttr)
+}
+
+// HasMore returns an error, since has_more_items describes older tweets. The
+// traversal ends on the first page without tweets instead.
+func (t *newerFeedPage) HasMore() (bool, error) {
+	return false, errors.New("Page doesn't tell whether newer tweets exist")
+}
+
+// HasMore reports whether Twitter has more pages of the feed according to the
+// has_more_items attribute.
+func (t *FeedPage) HasMore() (bool, error) {
+	return t.lookupBool("has_more_items")
+}
+
+// SuggestedRefresh returns the interval Twitter suggests for polling the top
+// of the feed for new tweets, as given by focused_refresh_interval attribute
+// (in milliseconds). Returns false if the page carries no such hint.
+func (t *FeedPage) SuggestedRefresh() (time.Duration, bool) {
+	interval, ok := t.json["focused_refresh_interval"].(float64)
+	if !ok || interval <= 0 {
+		return 0, false
+	}
+	return time.Duration(interval) * time.Millisecond, true
+}
+
+// NewLatentCount returns the value of new_latent_count attribute, which is the
+// number of tweets Twitter considers new since the page's position. Returns
+// false if the attribute is absent.
+func (t *FeedPage) NewLatentCount() (int, bool) {
+	count, ok := t.json["new_latent_count"].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int(count), true
+}
+
+// RawHTML returns page's items HTML the tweets are extracted from.
+func (t *FeedPage) RawHTML() (string, error) {
+	return t.lookupString("items_html")
+}
+
+// Raw returns the decoded JSON object of the page.
+//
+// The object is shared with the page and must not be modified.
+func (t *FeedPage) Raw() map[string]interface{} {
+	return t.json
+}
+
+// Size returns the size in bytes of page's decoded response body or zero if
+// the page wasn't retrieved by a cursor.
+//
+// Abnormally small pages may indicate that Twitter serves an empty shell
+// instead of the feed.
+func (t *FeedPage) Size() int {
+	return t.size
+}
+
+// RateLimit returns the number of requests remaining in the current rate
+// limit window and the time the window resets, as reported by
+// x-rate-limit-remaining and x-rate-limit-reset response headers. ok is false
+// if the response didn't carry them, which is the case for most endpoints.
+func (t *FeedPage) RateLimit() (remaining int, reset time.Time, ok bool) {
+	remaining, err := strconv.Atoi(t.header.Get("X-Rate-Limit-Remaining"))
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	resetUnix, err := strconv.ParseInt(t.header.Get("X-Rate-Limit-Reset"), 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return remaining, time.Unix(resetUnix, 0), true
+}
+
+// IsBlank reports whether page's HTML has no content at all, which is the case
+// for pages past the end of feed or for feeds without tweets.
+func (t *FeedPage) IsBlank() bool {
+	html, err := t.lookupString("items_html")
+	return err == nil && len(strings.TrimSpace(html)) == 0
+}
+
+// SkippedCount returns the number of tweets that were skipped by the last
+// call to GetTweets() because they couldn't be parsed.
+func (t *FeedPage) SkippedCount() int {
+	return len(t.skipped)
+}
+
+// log returns the logger of the page, falling back to the global logger.
+func (t *FeedPage) log() log.FieldLogger {
+	if t.logger == nil {
+		return log.StandardLogger()
+	}
+	return t.logger
+}
+
+// SkippedErrors returns errors of the tweets that were skipped by the last
+// call to GetTweets().
+func (t *FeedPage) SkippedErrors() []error {
+	return append([]error(nil), t.skipped...)
 }
 
 // GetMinPosition returns a position of this page within feed.
+//
+// Position is taken from min_position attribute, which may also be a number.
+// If the attribute is missing or its value can't be used, the position is
+// extracted from page's HTML instead.
 func (t *FeedPage) GetMinPosition() (string, error) {
-	pos, err := t.lookupString("min_position")
-	if err != nil {
+	value, exists := t.json[pagePositionAttr]
+	if exists && value == nil {
 		// Return an empty string, if min_position is null.
-		if _, exists := t.json["min_position"]; exists && t.json["min_position"] == nil {
-			return "", nil
-		} else if !exists {
-			log.Debug("No 'min_position' attribute is present, trying to extract manually")
-			minPosition, err := t.extractMinPosition()
-			if err != nil {
-				return "", err
-			}
-			if len(minPosition) > 0 {
-				log.Debugf("Successfully extracted 'min_position' (= '%s')", minPosition)
-				return minPosition, nil
-			}
-			log.Debugf("Coudln't extract min_position")
-			return "", nil
-		}
-		return "", err
+		return "", nil
+	}
+	if pos, ok := positionString(value); ok {
+		return pos, nil
 	}
-	return pos, nil
-}
 
-func (t *FeedPage) extractMinPosition() (string, error) {
-	pageHTML, err := t.lookupString("items_html")
+	if exists {
+		t.log().Debugf("Unusable 'min_position' attribute (type: %s), trying to extract manually",
+			reflect.TypeOf(value))
+	} else {
+		t.log().Debug("No 'min_position' attribute is present, trying to extract manually")
+	}
+	minPosition, err := t.extractMinPosition()
 	if err != nil {
 		return "", err
 	}
+	if len(minPosition) > 0 {
+		t.log().Debugf("Successfully extracted 'min_position' (= '%s')", minPosition)
+		return minPosition, nil
+	}
+	t.log().Debugf("Coudln't extract min_position")
+	return "", nil
+}
+
+// positionString converts a JSON value of a position attribute to string.
+// Numbers are accepted as long as they were decoded without loss of
+// precision.
+func positionString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		const maxExactInteger = 1 << 53
+		if v >= 0 && v < maxExactInteger && v == math.Trunc(v) {
+			return strconv.FormatInt(int64(v), 10), true
+		}
+	}
+	return "", false
+}
 
-	doc, err := gq.NewDocumentFromReader(strings.NewReader(pageHTML))
+func (t *FeedPage) extractMinPosition() (string, error) {
+	doc, err := t.document()
 	if err != nil {
 		return "", err
 	}
 
-	if tweetSel := doc.Find("li[data-item-type=tweet]"); tweetSel.Length() > 0 {
+	if tweetSel := doc.Find(t.css().Tweet); tweetSel.Length() > 0 {
 		if value, exists := tweetSel.Last().Attr("data-item-id"); exists {
 			return value, nil
 		}
@@ -96,40 +332,158 @@ func (t *FeedPage) extractMinPosition() (string, error) {
 
 func (t *FeedPage) extractEmbeddedTweetImages(sel *gq.Selection) (*TweetEmbeddedGallery, error) {
 	var imageURLs []string
-	sel.Find("div[data-image-url]").Each(func(_ int, imgSel *gq.Selection) {
+	var altTexts []string
+	var sizes []ImageSize
+	hasAltText, hasSize := false, false
+	var err error
+	sel.Find(t.css().Image).EachWithBreak(func(_ int, imgSel *gq.Selection) bool {
 		url, exists := imgSel.Attr("data-image-url")
-		if exists {
-			imageURLs = append(imageURLs, url)
-		} else {
-			panic("Selected node is missing expected attribute")
+		if !exists {
+			err = newAPICompatError("Selected node is missing expected attribute",
+				t.css().Image, imgSel, nil)
+			return false
+		}
+		imageURLs = append(imageURLs, url)
+
+		// Description is stored either on the container or on the image.
+		altText, exists := imgSel.Attr("alt")
+		if !exists {
+			altText = imgSel.Find(t.css().ImageElement).AttrOr("alt", "")
 		}
+		altText = strings.TrimSpace(altText)
+		altTexts = append(altTexts, altText)
+		hasAltText = hasAltText || len(altText) > 0
+
+		size := extractImageSize(imgSel, t.css().ImageElement)
+		sizes = append(sizes, size)
+		hasSize = hasSize || size != ImageSize{}
+		return true
 	})
+	if err != nil {
+		return nil, err
+	}
 	if len(imageURLs) > 0 {
-		return &TweetEmbeddedGallery{imageURLs}, nil
+		if !hasAltText {
+			altTexts = nil
+		}
+		if !hasSize {
+			sizes = nil
+		}
+		return &TweetEmbeddedGallery{imageURLs, altTexts, sizes}, nil
 	}
 	return nil, nil
 }
 
+// extractImageSize extracts dimensions of a gallery image from data-width and
+// data-height attributes of the image container or width and height of the
+// image element matched by imageSelector. Returns zero size if the dimensions
+// aren't known.
+func extractImageSize(sel *gq.Selection, imageSelector string) ImageSize {
+	candidates := []struct {
+		sel                   *gq.Selection
+		widthAttr, heightAttr string
+	}{
+		{sel, "data-width", "data-height"},
+		{sel.Find(imageSelector).First(), "data-width", "data-height"},
+		{sel.Find(imageSelector).First(), "width", "height"},
+	}
+	for _, candidate := range candidates {
+		width, widthErr := strconv.Atoi(candidate.sel.AttrOr(candidate.widthAttr, ""))
+		height, heightErr := strconv.Atoi(candidate.sel.AttrOr(candidate.heightAttr, ""))
+		if widthErr == nil && heightErr == nil && width > 0 && height > 0 {
+			return ImageSize{width, height}
+		}
+	}
+	return ImageSize{}
+}
+
 func (t *FeedPage) extractEmbeddedTweetCard(sel *gq.Selection) (*TweetEmbeddedCard, error) {
-	if cardSel := sel.Find("*[data-card-url]"); cardSel.Length() > 0 {
+	if cardSel := sel.Find(t.css().Card); cardSel.Length() > 0 {
 		if cardSel.Length() == 1 {
 			url, exists := cardSel.Attr("data-card-url")
 			if exists {
-				return &TweetEmbeddedCard{url}, nil
+				imageURL := cardSel.Find(t.css().CardImage).First().AttrOr("src", "")
+				return &TweetEmbeddedCard{url, imageURL}, nil
 			}
 
 			// Shouldn't reach here normally, otherwise it would mean that
 			// there's a bug in goquery.
-			panic("Selected node is missing expected attribute")
+			return nil, newAPICompatError("Selected node is missing expected attribute",
+				t.css().Card, cardSel, nil)
 		} else {
-			return nil, &APICompatError{"Found more than a single card embeddable", nil}
+			return nil, newAPICompatError("Found more than a single card embeddable",
+				t.css().Card, cardSel, nil)
 		}
 	}
 	return nil, nil
 }
 
+// extractEmbeddedTweetPoll extracts a poll. Share of votes is read from
+// data-percent attribute of a choice or from the text of its progress bar
+// (e.g. "45%"), and vote count from data-votes attribute of a choice. Voting
+// end time is read from data-end-time attribute holding a Unix timestamp.
+// Finished polls have "is-final" class or data-finished="true" attribute.
+func (t *FeedPage) extractEmbeddedTweetPoll(sel *gq.Selection) (*TweetEmbeddedPoll, error) {
+	pollSel := sel.Find(t.css().Poll).First()
+	if pollSel.Length() == 0 {
+		return nil, nil
+	}
+
+	poll := &TweetEmbeddedPoll{}
+	var err error
+	pollSel.Find(t.css().PollChoice).EachWithBreak(func(_ int, choiceSel *gq.Selection) bool {
+		option := PollOption{
+			Label: strings.TrimSpace(choiceSel.Find(t.css().PollChoiceLabel).First().Text()),
+		}
+		percent, exists := choiceSel.Attr("data-percent")
+		if !exists {
+			percent = choiceSel.Find(t.css().PollChoicePercent).First().Text()
+		}
+		percent = strings.TrimSuffix(strings.TrimSpace(percent), "%")
+		if len(percent) > 0 {
+			if option.Percent, err = strconv.ParseFloat(percent, 64); err != nil {
+				err = newAPICompatError("Unable to parse poll choice percentage",
+					t.css().PollChoicePercent, choiceSel, nil)
+				return false
+			}
+		}
+		if votes, exists := choiceSel.Attr("data-votes"); exists {
+			if option.Votes, err = strconv.Atoi(votes); err != nil {
+				err = newAPICompatError("Unable to parse poll choice votes",
+					t.css().PollChoice, choiceSel, nil)
+				return false
+			}
+		}
+		poll.Options = append(poll.Options, option)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(poll.Options) == 0 {
+		return nil, newAPICompatError("Poll has no choices", t.css().PollChoice, pollSel, nil)
+	}
+
+	endSel := pollSel
+	if !endSel.Is(t.css().PollEndTime) {
+		endSel = pollSel.Find(t.css().PollEndTime).First()
+	}
+	if endTime, exists := endSel.Attr("data-end-time"); exists {
+		unixTime, err := strconv.ParseInt(endTime, 10, 64)
+		if err != nil {
+			return nil, newAPICompatError("Unable to parse poll end time",
+				t.css().PollEndTime, endSel, nil)
+		}
+		poll.EndsAt = time.Unix(unixTime, 0)
+	}
+	poll.Finished = pollSel.Is(t.css().PollFinished) ||
+		pollSel.Find(t.css().PollFinished).Length() > 0 ||
+		pollSel.AttrOr("data-finished", "") == "true"
+	return poll, nil
+}
+
 func (t *FeedPage) extractEmbeddedTweetQuote(sel *gq.Selection) (*TweetEmbeddedQuote, error) {
-	switch quoteSel := sel.Find("div.QuoteTweet-link"); quoteSel.Length() {
+	switch quoteSel := sel.Find(t.css().Quote); quoteSel.Length() {
 	case 0:
 		// No `quote' node.
 		return nil, nil
@@ -137,28 +491,94 @@ func (t *FeedPage) extractEmbeddedTweetQuote(sel *gq.Selection) (*TweetEmbeddedQ
 		// Found the node.
 		href, exists := quoteSel.Attr("href")
 		if exists {
-			return &TweetEmbeddedQuote{"https://twitter.com" + href}, nil
+			quote := &TweetEmbeddedQuote{QuoteURL: "https://twitter.com" + href}
+			// Text and name are siblings of the link within the quoted tweet
+			// container. Either may be absent, which isn't an error.
+			containerSel := quoteSel.Closest(t.css().QuotedTweet)
+			if containerSel.Length() == 0 {
+				containerSel = sel
+			}
+			quote.QuotedText = strings.TrimSpace(
+				containerSel.Find(t.css().QuoteText).First().Text())
+			quote.QuotedDisplayName = strings.TrimSpace(
+				containerSel.Find(t.css().QuoteName).First().Text())
+			return quote, nil
 		}
-		return nil, &APICompatError{"Quote HTML node is missing URL", nil}
+		return nil, newAPICompatError("Quote HTML node is missing URL",
+			t.css().Quote, quoteSel, nil)
 	default:
 		// Stumbling in here indicates that something's changed in Twitter's
 		// HTML.
-		return nil, &APICompatError{"Found more than a single quote embeddable", nil}
+		return nil, newAPICompatError("Found more than a single quote embeddable",
+			t.css().Quote, quoteSel, nil)
+	}
+}
+
+// backgroundImageRegexp extracts URL of background image from inline style.
+var backgroundImageRegexp = regexp.MustCompile(`background-image:\s*url\(['"]?([^'")]+)['"]?\)`)
+
+// extractEmbeddedTweetGIF extracts an animated GIF. The GIF player shows a
+// thumbnail as its background, which is served next to the MP4 file of the
+// GIF, so the URL of the latter is derived from the thumbnail URL unless the
+// markup includes a <video> element.
+func (t *FeedPage) extractEmbeddedTweetGIF(sel *gq.Selection) (*TweetEmbeddedGIF, error) {
+	gifSel := sel.Find(t.css().GIF).First()
+	if gifSel.Length() == 0 {
+		return nil, nil
+	}
+
+	var thumbnailURL string
+	playerSel := gifSel.Find(t.css().Video).AddSelection(gifSel).Filter("[style]").First()
+	if match := backgroundImageRegexp.FindStringSubmatch(playerSel.AttrOr("style", "")); match != nil {
+		thumbnailURL = match[1]
 	}
+	videoURL := gifSel.Find(t.css().GIFVideo).First().AttrOr("src", "")
+	if len(videoURL) == 0 {
+		videoURL = gifVideoURL(thumbnailURL)
+	}
+	if len(videoURL) == 0 {
+		return nil, newAPICompatError("Unable to find URL of animated GIF", t.css().GIF, gifSel, nil)
+	}
+	return &TweetEmbeddedGIF{VideoURL: videoURL, ThumbnailURL: thumbnailURL}, nil
+}
+
+// gifVideoURL derives URL of GIF's MP4 file from URL of its thumbnail, e.g.
+// https://pbs.twimg.com/tweet_video_thumb/X.jpg is turned into
+// https://video.twimg.com/tweet_video/X.mp4. Returns an empty string if the
+// thumbnail URL has unexpected format.
+func gifVideoURL(thumbnailURL string) string {
+	const thumbPath = "/tweet_video_thumb/"
+	i := strings.Index(thumbnailURL, thumbPath)
+	if i < 0 {
+		return ""
+	}
+	name := thumbnailURL[i+len(thumbPath):]
+	if j := strings.IndexAny(name, ".?:"); j >= 0 {
+		name = name[:j]
+	}
+	if len(name) == 0 {
+		return ""
+	}
+	return "https://video.twimg.com/tweet_video/" + name + ".mp4"
 }
 
 func (t *FeedPage) extractEmbeddedTweetVideo(sel *gq.Selection) (*TweetEmbeddedVideo, error) {
 	// TODO: implement support for extracting embedded videos.
-	if videoSel := sel.Find("div.PlayableMedia-player"); videoSel.Length() > 0 {
-		log.Debug("Extracting videos is not implemented yet")
+	videoSel := sel.Find(t.css().Video).FilterFunction(func(_ int, playerSel *gq.Selection) bool {
+		return playerSel.Closest(t.css().GIF).Length() == 0
+	})
+	if videoSel.Length() > 0 {
+		t.log().Debug("Extracting videos is not implemented yet")
 	}
 	return nil, nil
 }
 
 func (t *FeedPage) extractTweetExtra(sel *gq.Selection) (interface{}, error) {
 	var imageExtra *TweetEmbeddedGallery
+	var pollExtra *TweetEmbeddedPoll
 	var cardExtra *TweetEmbeddedCard
 	var quoteExtra *TweetEmbeddedQuote
+	var gifExtra *TweetEmbeddedGIF
 	var videoExtra *TweetEmbeddedVideo
 	var err error
 	if imageExtra, err = t.extractEmbeddedTweetImages(sel); imageExtra != nil {
@@ -166,6 +586,11 @@ func (t *FeedPage) extractTweetExtra(sel *gq.Selection) (interface{}, error) {
 	} else if err != nil {
 		return nil, err
 	}
+	if pollExtra, err = t.extractEmbeddedTweetPoll(sel); pollExtra != nil {
+		return pollExtra, nil
+	} else if err != nil {
+		return nil, err
+	}
 	if cardExtra, err = t.extractEmbeddedTweetCard(sel); cardExtra != nil {
 		return cardExtra, nil
 	} else if err != nil {
@@ -176,6 +601,11 @@ func (t *FeedPage) extractTweetExtra(sel *gq.Selection) (interface{}, error) {
 	} else if err != nil {
 		return nil, err
 	}
+	if gifExtra, err = t.extractEmbeddedTweetGIF(sel); gifExtra != nil {
+		return gifExtra, nil
+	} else if err != nil {
+		return nil, err
+	}
 	if videoExtra, err = t.extractEmbeddedTweetVideo(sel); videoExtra != nil {
 		return videoExtra, nil
 	} else if err != nil {
@@ -190,82 +620,383 @@ func (t *FeedPage) extractTweet(sel *gq.Selection) (*Tweet, error) {
 	var tweetID uint64
 	var date time.Time
 	var text string
+	var lang string
 	var extra interface{}
 	var err error
 
-	// Extract tweet ID.
-	if val, exists := sel.Attr("data-item-id"); exists {
+	// Extract tweet ID. Permalink pages don't wrap the tweet into a stream
+	// item, so the ID is taken from the tweet node itself.
+	val, exists := sel.Attr("data-item-id")
+	if !exists {
+		val, exists = sel.Find(t.css().TweetID).First().Attr("data-tweet-id")
+	}
+	if exists {
 		if tweetID, err = strconv.ParseUint(val, 10, 64); err != nil {
 			msg := fmt.Sprintf("Unable to parse tweet id: %s", err.Error())
-			return nil, &APICompatError{msg, nil}
+			return nil, newAPICompatError(msg, "li[data-item-id]", sel, nil)
 		}
 	} else {
-		return nil, &APICompatError{"Tweet ID not found", nil}
+		return nil, newAPICompatError("Tweet ID not found", "li[data-item-id]", sel, nil)
 	}
 
 	// Tweet date.
-	dateSel := sel.Find("*[data-time]")
+	var rawTime string
+	dateSel := sel.Find(t.css().Time)
 	if dateSel.Length() == 1 {
 		if dateStr, exists := dateSel.First().Attr("data-time"); exists {
 			if unixTime, err := strconv.ParseInt(dateStr, 10, 64); err == nil {
 				date = time.Unix(unixTime, 0)
+				rawTime = dateStr
 			} else {
-				msg := fmt.Sprintf("Unable to parse tweet id: %s", err.Error())
-				return nil, &APICompatError{msg, &tweetID}
+				msg := fmt.Sprintf("Unable to parse tweet date: %s", err.Error())
+				return nil, newAPICompatError(msg, t.css().Time, dateSel, &tweetID)
 			}
 		} else {
-			panic("Selected node is missing expected attribute")
+			return nil, newAPICompatError("Selected node is missing expected attribute",
+				t.css().Time, dateSel, &tweetID)
 		}
 	}
 
 	// Tweet text.
-	textSel := sel.Find("p.tweet-text")
+	textSel := sel.Find(t.css().Text)
 	if textSel.Length() == 1 {
 		text = textSel.First().Text()
+		lang = textSel.First().AttrOr("lang", "")
 	} else if textSel.Length() == 0 {
-		return nil, &APICompatError{"Tweet text not found", &tweetID}
+		return nil, newAPICompatError("Tweet text not found", t.css().Text, sel, &tweetID)
 	} else {
 		msg := fmt.Sprintf("Expected a single node containing tweet text, got %d instead",
 			textSel.Length())
-		return nil, &APICompatError{msg, &tweetID}
+		return nil, newAPICompatError(msg, t.css().Text, sel, &tweetID)
 	}
 
 	// Embedded elements.
 	if extra, err = t.extractTweetExtra(sel); err != nil {
 		// The extractTweetExtra() function doesn't get a handle of twitterID,
 		// so we have to fill it here.
-		err.(*APICompatError).tweetID = &tweetID
+		if compatErr, ok := err.(*APICompatError); ok {
+			compatErr.tweetID = &tweetID
+		}
 		return nil, err
 	}
 
+	// Conversation and reply context.
+	conversationID := tweetID
+	var inReplyToUsernames []string
+	conversationSel := sel.Find(t.css().Conversation)
+	if val, exists := conversationSel.Attr("data-conversation-id"); exists {
+		if conversationID, err = strconv.ParseUint(val, 10, 64); err != nil {
+			msg := fmt.Sprintf("Unable to parse conversation id: %s", err.Error())
+			return nil, newAPICompatError(msg, t.css().Conversation, conversationSel, &tweetID)
+		}
+	}
+	if conversationID != tweetID {
+		sel.Find(t.css().ReplyingTo).Each(func(_ int, linkSel *gq.Selection) {
+			href, _ := linkSel.Attr("href")
+			if username := strings.TrimPrefix(href, "/"); len(username) > 0 {
+				inReplyToUsernames = append(inReplyToUsernames, username)
+			}
+		})
+	}
+
+	// Pinned tweet marker.
+	pinned := sel.Is(t.css().Pinned) || sel.Find(t.css().Pinned).Length() > 0
+
+	// Author.
+	username := sel.Find(t.css().Author).First().AttrOr("data-screen-name", "")
+
+	// Retweet marker.
+	retweet := sel.Find(t.css().Retweet).Length() > 0
+
+	// Verification badge of the author.
+	verified := t.extractAuthorVerified(sel)
+
+	// Sensitive content warning.
+	sensitive := t.extractSensitive(sel)
+
+	// Place annotation.
+	location := t.extractTweetLocation(sel)
+
+	// Client the tweet was posted from.
+	source := t.extractTweetSource(sel)
+
+	// Hashtags, mentions and links.
+	entities := t.extractTweetEntities(sel, tweetID)
+
 	tweet := &Tweet{
-		ID:        tweetID,
-		Timestamp: date,
-		Text:      text,
-		Extra:     extra,
+		ID:                 tweetID,
+		Username:           username,
+		AuthorVerified:     verified,
+		Timestamp:          date,
+		RawTime:            rawTime,
+		Text:               text,
+		Lang:               lang,
+		Extra:              extra,
+		IsPinned:           pinned,
+		IsRetweet:          retweet,
+		Sensitive:          sensitive,
+		ConversationID:     conversationID,
+		InReplyToUsernames: inReplyToUsernames,
+		Location:           location,
+		Source:             source,
+		Hashtags:           entities.hashtags,
+		Mentions:           entities.mentions,
+		URLs:               entities.urls,
 	}
 	return tweet, nil
 }
 
+// extractAuthorVerified reports whether the header of a tweet carries
+// verification badge of the author. Quoted tweets have no such header, so
+// their authors' badges aren't considered.
+func (t *FeedPage) extractAuthorVerified(sel *gq.Selection) bool {
+	return sel.Find(t.css().VerifiedBadge).Length() > 0
+}
+
+// extractSensitive reports whether the tweet is marked as sensitive. Markers
+// inside a quoted tweet belong to that tweet and are ignored.
+func (t *FeedPage) extractSensitive(sel *gq.Selection) bool {
+	if sel.Is(t.css().Sensitive) {
+		return true
+	}
+	markers := sel.Find(t.css().Sensitive).FilterFunction(func(_ int, markerSel *gq.Selection) bool {
+		return markerSel.Closest(t.css().QuotedTweet).Length() == 0
+	})
+	return markers.Length() > 0
+}
+
+// tweetEntities holds hashtags, mentions and links of a tweet.
+type tweetEntities struct {
+	hashtags []string
+	mentions []string
+	urls     []string
+}
+
+// extractTweetEntities extracts hashtags, mentions and links of a tweet.
+//
+// Structured entities are preferred when the page JSON carries them in an
+// "entities" object keyed by tweet ID, using the format of Twitter's API
+// (e.g. {"hashtags": [{"text": "..."}]}). Otherwise they are scraped from
+// links in tweet's text.
+func (t *FeedPage) extractTweetEntities(sel *gq.Selection, tweetID uint64) tweetEntities {
+	if allEntities, ok := t.json["entities"].(map[string]interface{}); ok {
+		if entities, ok := allEntities[strconv.FormatUint(tweetID, 10)].(map[string]interface{}); ok {
+			return tweetEntities{
+				hashtags: collectEntityField(entities["hashtags"], "text"),
+				mentions: collectEntityField(entities["user_mentions"], "screen_name"),
+				urls:     collectEntityField(entities["urls"], "expanded_url"),
+			}
+		}
+	}
+
+	var entities tweetEntities
+	textSel := sel.Find(t.css().Text)
+	textSel.Find(t.css().Hashtag).Each(func(_ int, linkSel *gq.Selection) {
+		hashtag := strings.TrimPrefix(strings.TrimSpace(linkSel.Text()), "#")
+		if len(hashtag) > 0 {
+			entities.hashtags = append(entities.hashtags, hashtag)
+		}
+	})
+	textSel.Find(t.css().Mention).Each(func(_ int, linkSel *gq.Selection) {
+		href, _ := linkSel.Attr("href")
+		if username := strings.TrimPrefix(href, "/"); len(username) > 0 {
+			entities.mentions = append(entities.mentions, username)
+		}
+	})
+	textSel.Find(t.css().Link).Each(func(_ int, linkSel *gq.Selection) {
+		entities.urls = append(entities.urls, linkSel.AttrOr("data-expanded-url", ""))
+	})
+	return entities
+}
+
+// collectEntityField collects string values of given field from a JSON list
+// of entity objects. Entities without the field are skipped.
+func collectEntityField(list interface{}, field string) []string {
+	items, _ := list.([]interface{})
+	var values []string
+	for _, item := range items {
+		entity, _ := item.(map[string]interface{})
+		if value, ok := entity[field].(string); ok && len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// extractTweetSource extracts name of the client the tweet was posted from.
+// Returns an empty string if the markup doesn't include it.
+func (t *FeedPage) extractTweetSource(sel *gq.Selection) string {
+	sourceSel := sel.Find(t.css().Source).First()
+	if sourceSel.Length() == 0 {
+		return ""
+	}
+	if source, exists := sourceSel.Attr("data-source"); exists {
+		return strings.TrimSpace(source)
+	}
+
+	// Footer reads "via <client>" with the client linking to its website.
+	if linkSel := sourceSel.Find(t.css().SourceLink); linkSel.Length() > 0 {
+		return strings.TrimSpace(linkSel.First().Text())
+	}
+	source := strings.TrimSpace(sourceSel.Text())
+	for _, prefix := range []string{"via ", "from "} {
+		source = strings.TrimPrefix(source, prefix)
+	}
+	return strings.TrimSpace(source)
+}
+
+// extractTweetLocation extracts place annotation of a tweet. Returns nil if
+// the tweet has none.
+func (t *FeedPage) extractTweetLocation(sel *gq.Selection) *TweetLocation {
+	geoSel := sel.Find(t.css().Location).First()
+	if geoSel.Length() == 0 {
+		return nil
+	}
+
+	name := geoSel.AttrOr("title", "")
+	if len(name) == 0 {
+		name = geoSel.AttrOr("data-location", "")
+	}
+	if len(name) == 0 {
+		name = geoSel.Text()
+	}
+
+	placeID := geoSel.AttrOr("data-place-id", "")
+	if len(placeID) == 0 {
+		href, exists := geoSel.Attr("href")
+		if !exists {
+			href = geoSel.Find(t.css().LocationLink).AttrOr("href", "")
+		}
+		placeID = extractPlaceID(href)
+	}
+
+	name = strings.TrimSpace(name)
+	if len(name) == 0 && len(placeID) == 0 {
+		return nil
+	}
+	return &TweetLocation{Name: name, PlaceID: placeID}
+}
+
+// extractPlaceID extracts place ID from a link to place's page, which is
+// either "/places/<id>" or a search for "place:<id>".
+func extractPlaceID(href string) string {
+	parsedURL, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(parsedURL.Path, "/places/") {
+		return strings.TrimPrefix(parsedURL.Path, "/places/")
+	}
+	for _, term := range strings.Fields(parsedURL.Query().Get("q")) {
+		if strings.HasPrefix(term, "place:") {
+			return strings.TrimPrefix(term, "place:")
+		}
+	}
+	return ""
+}
+
+// parseItemsHTML parses HTML fragment holding page's items.
+//
+// Parse failures are returned rather than logged, so that a single malformed
+// page can't bring down the application.
+func parseItemsHTML(html string) (*gq.Document, error) {
+	doc, err := gq.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse feed HTML content: %s", err.Error())
+	}
+	return doc, nil
+}
+
+// document returns parsed HTML of page's items. The HTML is parsed on first
+// call only.
+func (t *FeedPage) document() (*gq.Document, error) {
+	t.docOnce.Do(func() {
+		var html string
+		if html, t.docErr = t.lookupString("items_html"); t.docErr == nil {
+			t.doc, t.docErr = parseItemsHTML(html)
+		}
+	})
+	return t.doc, t.docErr
+}
+
 func (t *FeedPage) extractTweets(html string) ([]*Tweet, error) {
-	var doc *gq.Document
-	var err error
-	var tweets []*Tweet
-	if doc, err = gq.NewDocumentFromReader(strings.NewReader(html)); err != nil {
-		log.WithFields(log.Fields{
-			"error": err.Error(),
-		}).Fatal("Unable to parse feed HTML content")
+	doc, err := parseItemsHTML(html)
+	if err != nil {
+		return nil, err
 	}
+	return t.extractDocumentTweets(doc)
+}
+
+func (t *FeedPage) extractDocumentTweets(doc *gq.Document) ([]*Tweet, error) {
+	var tweets []*Tweet
+	err := t.eachDocumentTweet(doc, func(tweet *Tweet) error {
+		tweets = append(tweets, tweet)
+		return nil
+	})
+	return tweets, err
+}
 
-	doc.Find("li[data-item-type=\"tweet\"]").EachWithBreak(func(_ int, sel *gq.Selection) bool {
-		if tweet, err := t.extractTweet(sel); err == nil {
-			tweets = append(tweets, tweet)
+func (t *FeedPage) eachDocumentTweet(doc *gq.Document, callback func(*Tweet) error) error {
+	var callbackErr error
+	parsed := 0
+	t.skipped = nil
+	doc.Find(t.css().Tweet).EachWithBreak(func(_ int, sel *gq.Selection) bool {
+		tweet, err := t.extractTweet(sel)
+		if err != nil {
+			t.log().WithFields(log.Fields{
+				"error": err.Error(),
+			}).Debug("Skipping tweet that failed to parse")
+			t.skipped = append(t.skipped, err)
 			return true
 		}
-		return false
+		parsed++
+		callbackErr = callback(tweet)
+		return callbackErr == nil
 	})
+	if callbackErr != nil {
+		return callbackErr
+	}
 
-	return tweets, err
+	if len(t.skipped) > 0 {
+		t.log().Warnf("Skipped %d tweet(s) that failed to parse", len(t.skipped))
+		if parsed == 0 {
+			return t.skipped[0]
+		}
+	}
+	return nil
+}
+
+// maxSnippetLength is the maximum length of HTML snippet attached to
+// APICompatError.
+const maxSnippetLength = 512
+
+// newAPICompatError creates an error describing a failure to extract data
+// from the node matched by selector.
+func newAPICompatError(msg, selector string, sel *gq.Selection, tweetID *uint64) *APICompatError {
+	snippet, _ := gq.OuterHtml(sel.First())
+	if len(snippet) > maxSnippetLength {
+		snippet = snippet[:maxSnippetLength] + "..."
+	}
+	return &APICompatError{
+		msg:      msg,
+		tweetID:  tweetID,
+		selector: selector,
+		snippet:  snippet,
+	}
+}
+
+func (t *FeedPage) lookupBool(name string) (bool, error) {
+	value, ok := t.json[name].(bool)
+	if !ok {
+		if _, exists := t.json[name]; !exists {
+			msg := fmt.Sprintf("Key '%s' does not exist in JSON object", name)
+			return false, errors.New(msg)
+		}
+		msg := "Can't convert '%s' (type: %s) to bool"
+		msg = fmt.Sprintf(msg, name, reflect.TypeOf(t.json[name]))
+		return false, errors.New(msg)
+	}
+	return value, nil
 }
 
 func (t *FeedPage) lookupString(name string) (string, error) {
@@ -1,6 +1,9 @@
 package rattler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -9,7 +12,10 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -63,7 +69,7 @@ func checkMaxPosition(t *testing.T, expected uint64, url *url.URL) {
 func TestTweetExraction(t *testing.T) {
 	t.Log("Testing extraction of well-formed data ...")
 	for i := 1; i <= 3; i++ {
-		page := FeedPage{nil}
+		page := FeedPage{}
 		filename := fmt.Sprintf("testdata/items%d.html", i)
 		t.Logf("Extracting tweets from %s", filename)
 		itemsHTML := readTextFileOrDie(filename)
@@ -75,9 +81,247 @@ func TestTweetExraction(t *testing.T) {
 		}
 
 		assert.Equal(t, 20, len(tweets), "Extraction returned unexpected number of tweets")
+		assert.Equal(t, 0, page.SkippedCount())
 	}
 }
 
+func TestTweetExtractionSkipsMalformed(t *testing.T) {
+	itemsHTML := `
+		<li data-item-type="tweet" data-item-id="1"><p class="tweet-text">First</p></li>
+		<li data-item-type="tweet" data-item-id="bogus"><p class="tweet-text">Second</p></li>
+		<li data-item-type="tweet" data-item-id="3"><p class="tweet-text">Third</p></li>`
+
+	page := FeedPage{}
+	tweets, err := page.extractTweets(itemsHTML)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(tweets))
+	assert.Equal(t, uint64(1), tweets[0].ID)
+	assert.Equal(t, uint64(3), tweets[1].ID)
+	assert.Equal(t, 1, page.SkippedCount())
+
+	// A page where nothing can be parsed is reported as an error.
+	_, err = page.extractTweets(`<li data-item-type="tweet" data-item-id="1"></li>`)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, page.SkippedCount())
+}
+
+func TestSelectorOverrides(t *testing.T) {
+	itemsHTML := `
+		<div class="tweet" data-item-id="1">
+			<div class="tweet-body">Hello <a class="twitter-hashtag">#go</a></div>
+		</div>`
+
+	page := FeedPage{}
+	_, err := page.extractTweets(itemsHTML)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, page.SkippedCount())
+
+	selectors := Selectors{Tweet: "div.tweet", Text: "div.tweet-body"}
+	page.SetSelectors(selectors)
+	tweets, err := page.extractTweets(itemsHTML)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tweets))
+	assert.Equal(t, "Hello #go", tweets[0].Text)
+	assert.Equal(t, []string{"go"}, tweets[0].Hashtags)
+
+	// Pages retrieved by cursors use selectors of the client.
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(map[string]interface{}{"items_html": itemsHTML})
+		w.Write(data)
+	})
+	defer server.Close()
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	cursor.SetClient(NewTwitterHTTP(WithTransport(client.Transport), WithSelectors(selectors)))
+	reader, err := cursor.RetrievePage()
+	require.Nil(t, err)
+	tweets, err = reader.GetTweets()
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(tweets))
+
+	assert.Equal(t, DefaultSelectors(), Selectors{}.withDefaults())
+}
+
+func TestSubSelectorOverrides(t *testing.T) {
+	page := FeedPage{}
+	page.SetSelectors(Selectors{ImageElement: "picture", CardImage: "span[src]"})
+	tweets, err := page.extractTweets(`
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Photo</p>
+			<div data-image-url="https://pbs.twimg.com/media/a.jpg">
+				<picture alt="A cat" width="640" height="480"></picture>
+			</div>
+		</li>
+		<li data-item-type="tweet" data-item-id="2">
+			<p class="tweet-text">Card</p>
+			<div data-card-url="https://t.co/card"><span src="https://pbs.twimg.com/card.jpg"></span></div>
+		</li>`)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(tweets))
+	gallery := tweets[0].Extra.(*TweetEmbeddedGallery)
+	assert.Equal(t, []string{"A cat"}, gallery.AltTexts)
+	assert.Equal(t, []ImageSize{{640, 480}}, gallery.Sizes)
+	card := tweets[1].Extra.(*TweetEmbeddedCard)
+	assert.Equal(t, "https://pbs.twimg.com/card.jpg", card.ImageURL)
+}
+
+func TestBrokenMarkupDoesNotPanic(t *testing.T) {
+	fragments := []string{
+		`<li data-item-type="tweet" data-item-id="1"><p class="tweet-text">Unclosed`,
+		`<li data-item-type="tweet" data-item-id="1"><span data-time=""></span></li>`,
+		`<li data-item-type="tweet" data-item-id="1"><p class="tweet-text"></p>` +
+			`<div data-image-url><div data-card-url><div data-card-url></li>`,
+		`<li data-item-type="tweet" data-item-id="1"><p class="tweet-text"></p>` +
+			`<div class="QuoteTweet-link"></div><div data-conversation-id="x"></div>`,
+		`<li data-item-type="tweet"><<<>>></li></ul></li>`,
+		`<li data-item-type="tweet" data-item-id="-1" <p class="tweet-text">`,
+	}
+	for _, fragment := range fragments {
+		page := NewFeedPage(map[string]interface{}{"items_html": fragment})
+		assert.NotPanics(t, func() {
+			page.GetTweetsWithErrors()
+			page.GetMinPosition()
+		}, fragment)
+	}
+}
+
+func TestOfflineFeedPage(t *testing.T) {
+	data := readTextFileOrDie("testdata/items1.json")
+	page, err := NewFeedPageFromJSON([]byte(data))
+	require.Nil(t, err)
+	fromJSON, err := page.GetTweets()
+	require.Nil(t, err)
+	assert.Equal(t, 20, len(fromJSON))
+	assert.Equal(t, len(data), page.Size())
+
+	itemsHTML, err := page.RawHTML()
+	require.Nil(t, err)
+	page = NewFeedPageFromHTML(itemsHTML)
+	fromHTML, err := page.GetTweets()
+	require.Nil(t, err)
+	assert.Equal(t, fromJSON, fromHTML)
+	position, err := page.GetMinPosition()
+	require.Nil(t, err)
+	assert.Equal(t, strconv.FormatUint(fromHTML[len(fromHTML)-1].ID, 10), position)
+
+	_, err = NewFeedPageFromJSON([]byte("[]"))
+	assert.NotNil(t, err)
+	_, err = NewFeedPageFromJSON([]byte("{"))
+	assert.NotNil(t, err)
+}
+
+func TestFeedPageRaw(t *testing.T) {
+	page := NewFeedPage(map[string]interface{}{
+		"items_html":   "<li></li>",
+		"min_position": "123",
+		"new_latent":   float64(2),
+	})
+	html, err := page.RawHTML()
+	require.Nil(t, err)
+	assert.Equal(t, "<li></li>", html)
+	assert.Equal(t, float64(2), page.Raw()["new_latent"])
+
+	_, err = NewFeedPage(map[string]interface{}{}).RawHTML()
+	assert.NotNil(t, err)
+}
+
+func TestFeedPageRefreshHints(t *testing.T) {
+	page := NewFeedPage(map[string]interface{}{
+		"focused_refresh_interval": float64(30000),
+		"new_latent_count":         float64(19),
+	})
+	interval, ok := page.SuggestedRefresh()
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, interval)
+	count, ok := page.NewLatentCount()
+	assert.True(t, ok)
+	assert.Equal(t, 19, count)
+
+	page = NewFeedPage(map[string]interface{}{})
+	_, ok = page.SuggestedRefresh()
+	assert.False(t, ok)
+	_, ok = page.NewLatentCount()
+	assert.False(t, ok)
+}
+
+func TestMinPositionFallback(t *testing.T) {
+	const itemsHTML = `
+		<li data-item-type="tweet" data-item-id="20"><p class="tweet-text">First</p></li>
+		<li data-item-type="tweet" data-item-id="10"><p class="tweet-text">Second</p></li>`
+
+	positions := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"123", "123"},
+		{float64(123), "123"},
+		{json.Number("123"), "123"},
+		{float64(1e19), "10"},
+		{true, "10"},
+		{[]interface{}{"123"}, "10"},
+		{map[string]interface{}{}, "10"},
+	}
+	for _, test := range positions {
+		page := NewFeedPage(map[string]interface{}{"items_html": itemsHTML, "min_position": test.value})
+		position, err := page.GetMinPosition()
+		require.Nil(t, err, "%v", test.value)
+		assert.Equal(t, test.expected, position, "%v", test.value)
+	}
+
+	page := NewFeedPage(map[string]interface{}{"items_html": itemsHTML, "min_position": nil})
+	position, err := page.GetMinPosition()
+	require.Nil(t, err)
+	assert.Equal(t, "", position)
+}
+
+func TestGetTweetsWithErrors(t *testing.T) {
+	itemsHTML := `
+		<li data-item-type="tweet" data-item-id="1"><p class="tweet-text">First</p></li>
+		<li data-item-type="tweet" data-item-id="bogus"><p class="tweet-text">Second</p></li>
+		<li data-item-type="tweet" data-item-id="3"></li>`
+
+	page := NewFeedPage(map[string]interface{}{"items_html": itemsHTML})
+	tweets, errs := page.GetTweetsWithErrors()
+	require.Equal(t, 1, len(tweets))
+	assert.Equal(t, uint64(1), tweets[0].ID)
+	require.Equal(t, 2, len(errs))
+	assert.Contains(t, errs[0].Error(), "Unable to parse tweet id")
+	assert.Contains(t, errs[1].Error(), "Tweet text not found")
+
+	page = NewFeedPage(map[string]interface{}{})
+	tweets, errs = page.GetTweetsWithErrors()
+	assert.Empty(t, tweets)
+	assert.Equal(t, 1, len(errs))
+}
+
+func TestEachTweet(t *testing.T) {
+	page := NewFeedPage(map[string]interface{}{"items_html": `
+		<li data-item-type="tweet" data-item-id="1"><p class="tweet-text">First</p></li>
+		<li data-item-type="tweet" data-item-id="bogus"><p class="tweet-text">Second</p></li>
+		<li data-item-type="tweet" data-item-id="3"><p class="tweet-text">Third</p></li>
+		<li data-item-type="tweet" data-item-id="4"><p class="tweet-text">Fourth</p></li>`})
+
+	var ids []uint64
+	stop := errors.New("stop")
+	err := page.EachTweet(func(tweet *Tweet) error {
+		ids = append(ids, tweet.ID)
+		if tweet.ID == 3 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []uint64{1, 3}, ids)
+	assert.Equal(t, 1, page.SkippedCount())
+
+	page = NewFeedPage(map[string]interface{}{"items_html": `
+		<li data-item-type="tweet" data-item-id="1"></li>`})
+	err = page.EachTweet(func(tweet *Tweet) error {
+		assert.Fail(t, "Unexpected tweet")
+		return nil
+	})
+	assert.NotNil(t, err)
+}
+
 func TestLiveRetrieval(t *testing.T) {
 	requestHandlers := []func(http.ResponseWriter, *http.Request){
 		func(w http.ResponseWriter, r *http.Request) {
@@ -140,3 +384,1187 @@ func TestLiveRetrievalHTTPError(t *testing.T) {
 
 	require.Equal(t, 1, iterations)
 }
+
+// setupFixtureSession creates a session whose cursor receives fixture files in
+// the given order, one file per request.
+func setupFixtureSession(t *testing.T, filenames ...string) (*TwitterSession, *httptest.Server) {
+	requestIndex := 0
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requestIndex >= len(filenames) {
+				assert.Fail(t, "Unexpected request: %s", r.URL.RequestURI())
+				return
+			}
+			fmt.Fprint(w, readTextFileOrDie(filenames[requestIndex]))
+			requestIndex++
+		}))
+
+	session := NewTwitterSession(NewGenericFeedCursor("test", FeedTypeMedia))
+	session.cursor.(*GenericFeedCursor).client.httpClient = client
+	return session, server
+}
+
+func TestFeedIterWaitForRateLimit(t *testing.T) {
+	var requested []time.Time
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, time.Now())
+			if len(requested) == 1 {
+				reset := time.Now().Add(time.Second).Unix() + 1
+				w.Header().Set("X-Rate-Limit-Remaining", "0")
+				w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(reset, 10))
+				fmt.Fprint(w, readTextFileOrDie("testdata/items1.json"))
+			} else {
+				fmt.Fprint(w, readTextFileOrDie("testdata/items4.json"))
+			}
+		}))
+	defer server.Close()
+	session := NewTwitterSession(NewGenericFeedCursor("test", FeedTypeMedia))
+	session.cursor.(*GenericFeedCursor).client.httpClient = client
+
+	var limits []bool
+	onPage := func(page FeedPageReader, position string) error {
+		remaining, reset, ok := page.(*FeedPage).RateLimit()
+		limits = append(limits, ok)
+		if ok {
+			assert.Equal(t, 0, remaining)
+			assert.True(t, reset.After(time.Now()))
+		}
+		return nil
+	}
+	for result := range session.FeedIter(WaitForRateLimit(), OnPage(onPage)) {
+		require.Nil(t, result.Error)
+	}
+	require.Equal(t, 2, len(requested))
+	assert.True(t, requested[1].Sub(requested[0]) >= time.Second)
+	assert.Equal(t, []bool{true, false}, limits)
+}
+
+func TestLogger(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(log.DebugLevel)
+
+	session, server := setupFixtureSession(t,
+		"testdata/items1.json", "testdata/items1-repeated.json", "testdata/items4.json")
+	defer server.Close()
+	WithSessionLogger(logger)(session)
+	WithLogger(logger)(session.cursor.(*GenericFeedCursor).client)
+
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+	}
+
+	duplicates := 0
+	var fetched []log.Fields
+	for _, entry := range hook.AllEntries() {
+		switch entry.Message {
+		case "Duplicate tweet":
+			duplicates++
+		case "Fetched page":
+			fetched = append(fetched, entry.Data)
+		}
+	}
+	assert.True(t, duplicates > 0)
+
+	// Page fetches carry fields identifying the feed and the page.
+	require.Equal(t, 3, len(fetched))
+	assert.Equal(t, "test", fetched[1]["username"])
+	assert.Equal(t, "media", fetched[1]["feed"])
+	assert.Equal(t, "608164787940413441", fetched[1]["position"])
+	assert.Equal(t, 2, fetched[1]["page"])
+	assert.Contains(t, fetched[1], "duration")
+	assert.Contains(t, fetched[1], "bytes")
+
+	// Unparseable tweets are reported into the page's logger.
+	hook.Reset()
+	page := FeedPage{logger: logger}
+	_, err := page.extractTweets(`
+		<li data-item-type="tweet" data-item-id="1"><p class="tweet-text">First</p></li>
+		<li data-item-type="tweet" data-item-id="2"></li>`)
+	require.Nil(t, err)
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, log.WarnLevel, hook.LastEntry().Level)
+}
+
+// panickingCursor is a FeedCursor that panics on retrieval.
+type panickingCursor struct{}
+
+func (t *panickingCursor) RetrievePage() (FeedPageReader, error) { panic("boom") }
+func (t *panickingCursor) Seek(position string) bool             { return true }
+func (t *panickingCursor) Position() string                      { return "42" }
+
+func TestFeedIterRecoversPanics(t *testing.T) {
+	var results []FeedIterResult
+	for result := range NewTwitterSession(&panickingCursor{}).FeedIter() {
+		results = append(results, result)
+	}
+	require.Equal(t, 1, len(results))
+	require.NotNil(t, results[0].Error)
+	assert.Contains(t, results[0].Error.Error(), "boom")
+	assert.Equal(t, "42", results[0].Position)
+
+	session, server := setupFixtureSession(t, "testdata/items1.json")
+	defer server.Close()
+	results = nil
+	panicky := Filter(func(*Tweet) bool { panic("filter") })
+	for result := range session.FeedIter(SinglePage(), panicky) {
+		results = append(results, result)
+	}
+	require.Equal(t, 1, len(results))
+	require.NotNil(t, results[0].Error)
+	assert.Contains(t, results[0].Error.Error(), "filter")
+}
+
+func TestFeedIterLimits(t *testing.T) {
+	count := func(session *TwitterSession, options ...FeedIterOption) int {
+		n := 0
+		for result := range session.FeedIter(options...) {
+			require.Nil(t, result.Error)
+			n++
+		}
+		return n
+	}
+
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+	assert.Equal(t, 20, count(session, MaxPages(1)))
+	assert.NotEmpty(t, session.Position())
+	assert.Equal(t, TerminationLimitReached, session.TerminationReason())
+
+	// Resuming retrieves the next page, which is empty.
+	result := <-session.FeedIter(MaxPages(1))
+	assert.Equal(t, ErrEmptyFeed, result.Error)
+
+	// The next page may be prefetched before the limit is reached.
+	session, server2 := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server2.Close()
+	assert.Equal(t, 5, count(session, MaxTweets(5), MaxPages(10)))
+	assert.Equal(t, TerminationLimitReached, session.TerminationReason())
+	// The cursor is moved back to the page that hasn't been fully emitted.
+	assert.Equal(t, "", session.Position())
+
+	session, server3 := setupFixtureSession(t, "testdata/items1.json")
+	defer server3.Close()
+	WithPageDelay(time.Hour, 0)(session)
+	start := time.Now()
+	assert.Equal(t, 20, count(session, MaxDuration(50*time.Millisecond)))
+	assert.True(t, time.Since(start) < time.Minute)
+	assert.Equal(t, TerminationLimitReached, session.TerminationReason())
+}
+
+func TestFeedIterMaxTweetsResume(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(r.URL.Query().Get("max_position")) == 0 {
+				fmt.Fprint(w, readTextFileOrDie("testdata/items1.json"))
+			} else {
+				fmt.Fprint(w, readTextFileOrDie("testdata/items4.json"))
+			}
+		}))
+	defer server.Close()
+	session := NewTwitterSession(NewGenericFeedCursor("test", FeedTypeMedia))
+	session.cursor.(*GenericFeedCursor).client.httpClient = client
+
+	ids := make(map[uint64]bool)
+	emitted := 0
+	for _, options := range [][]FeedIterOption{{MaxTweets(5)}, {}} {
+		for result := range session.FeedIter(options...) {
+			require.Nil(t, result.Error)
+			ids[result.Tweet.ID] = true
+			emitted++
+		}
+	}
+	// Resuming emits the rest of the interrupted page without duplicates.
+	assert.Equal(t, 20, len(ids))
+	assert.Equal(t, 20, emitted)
+	assert.Equal(t, TerminationCompleted, session.TerminationReason())
+}
+
+func TestFeedIterTerminationReason(t *testing.T) {
+	drain := func(tweets <-chan FeedIterResult) {
+		for range tweets {
+		}
+	}
+
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+	assert.Equal(t, TerminationNone, session.TerminationReason())
+	drain(session.FeedIter())
+	assert.Equal(t, TerminationCompleted, session.TerminationReason())
+
+	session, server2 := setupFixtureSession(t, "testdata/items4.json")
+	defer server2.Close()
+	drain(session.FeedIter())
+	assert.Equal(t, TerminationError, session.TerminationReason())
+
+	session, server3 := setupFixtureSession(t, "testdata/items1.json")
+	defer server3.Close()
+	WithPageDelay(time.Hour, 0)(session)
+	tweets := session.FeedIter()
+	<-tweets
+	session.Close()
+	drain(tweets)
+	assert.Equal(t, TerminationCancelled, session.TerminationReason())
+	assert.Equal(t, "cancelled", session.TerminationReason().String())
+
+	// Abandoning the iterator is reported once the consumer cancels it.
+	session, server4 := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server4.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	tweets = session.FeedIter(Context(ctx))
+	<-tweets
+	assert.Equal(t, TerminationNone, session.TerminationReason())
+	cancel()
+	drain(tweets)
+	assert.Equal(t, TerminationCancelled, session.TerminationReason())
+}
+
+func TestFeedIterOnProgress(t *testing.T) {
+	session, server := setupFixtureSession(t,
+		"testdata/items1.json", "testdata/items2.json", "testdata/items4.json")
+	defer server.Close()
+
+	var reports []Progress
+	var last *Tweet
+	for result := range session.FeedIter(OnProgress(1, func(progress Progress) {
+		reports = append(reports, progress)
+	})) {
+		require.Nil(t, result.Error)
+		last = result.Tweet
+	}
+	require.Equal(t, 2, len(reports))
+	assert.Equal(t, 1, reports[0].PagesFetched)
+	assert.Equal(t, 20, reports[0].TweetsEmitted)
+	assert.Equal(t, 2, reports[1].PagesFetched)
+	assert.Equal(t, 40, reports[1].TweetsEmitted)
+	assert.Equal(t, last.Timestamp, reports[1].Timestamp)
+	assert.True(t, reports[1].Timestamp.Before(reports[0].Timestamp))
+	assert.True(t, reports[1].Elapsed >= reports[0].Elapsed)
+}
+
+func TestFeedIterPageDelay(t *testing.T) {
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+	WithPageDelay(100*time.Millisecond, 0)(session)
+
+	start := time.Now()
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	WithPageDelay(10*time.Millisecond, 5*time.Millisecond)(session)
+	for i := 0; i < 100; i++ {
+		delay := session.nextPageDelay()
+		assert.True(t, delay >= 10*time.Millisecond && delay < 15*time.Millisecond)
+	}
+	assert.Equal(t, time.Duration(0), NewTwitterSession(nil).nextPageDelay())
+}
+
+func TestSessionStats(t *testing.T) {
+	filenames := []string{"testdata/items1.json", "testdata/items1-repeated.json", "testdata/items4.json"}
+	session, server := setupFixtureSession(t, filenames...)
+	defer server.Close()
+	assert.Equal(t, Stats{}, session.Stats())
+
+	count := 0
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+		count++
+	}
+
+	stats := session.Stats()
+	assert.Equal(t, int64(3), stats.PagesFetched)
+	assert.Equal(t, int64(count), stats.TweetsEmitted)
+	assert.True(t, stats.DuplicatesDropped > 0)
+
+	expectedBytes := 0
+	for _, filename := range filenames {
+		expectedBytes += len(readTextFileOrDie(filename))
+	}
+	assert.Equal(t, int64(expectedBytes), stats.BytesRead)
+}
+
+func TestSessionClose(t *testing.T) {
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+	WithPageDelay(time.Hour, 0)(session)
+
+	tweets := session.FeedIter()
+	result := <-tweets
+	require.Nil(t, result.Error)
+
+	closed := make(chan struct{})
+	go func() {
+		session.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "Close() didn't stop the iterator")
+	}
+	for range tweets {
+	}
+	assert.Equal(t, 0, session.seenTweets.(*tweetIDLRU).Len())
+
+	var results []FeedIterResult
+	for result := range session.FeedIter() {
+		results = append(results, result)
+	}
+	require.Equal(t, 1, len(results))
+	assert.Equal(t, ErrSessionClosed, results[0].Error)
+	assert.Nil(t, session.Close())
+
+	// Closing a drained session changes nothing but the dedupe state.
+	session, server2 := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server2.Close()
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+	}
+	stats := session.Stats()
+	assert.Nil(t, session.Close())
+	assert.Equal(t, stats, session.Stats())
+}
+
+func TestFeedIterOnPage(t *testing.T) {
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+
+	var positions []string
+	record := OnPage(func(page FeedPageReader, position string) error {
+		positions = append(positions, position)
+		return nil
+	})
+	for result := range session.FeedIter(record) {
+		require.Nil(t, result.Error)
+	}
+	require.Equal(t, 2, len(positions))
+	assert.Equal(t, "", positions[0])
+	assert.NotEmpty(t, positions[1])
+
+	session, server2 := setupFixtureSession(t, "testdata/items1.json")
+	defer server2.Close()
+	abort := OnPage(func(page FeedPageReader, position string) error {
+		return errors.New("abort")
+	})
+	var results []FeedIterResult
+	for result := range session.FeedIter(abort) {
+		results = append(results, result)
+	}
+	require.Equal(t, 1, len(results))
+	assert.EqualError(t, results[0].Error, "abort")
+}
+
+func TestFeedIterHasMore(t *testing.T) {
+	var items map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(readTextFileOrDie("testdata/items1.json")), &items))
+	items["has_more_items"] = false
+	lastPage, err := json.Marshal(items)
+	require.Nil(t, err)
+
+	requests := 0
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(lastPage)
+	})
+	defer server.Close()
+
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	cursor.client.httpClient = client
+	count := 0
+	for result := range NewTwitterSession(cursor).FeedIter() {
+		require.Nil(t, result.Error)
+		count++
+	}
+	assert.Equal(t, 1, requests)
+	assert.True(t, count > 0)
+
+	page := NewFeedPage(map[string]interface{}{})
+	_, err = page.HasMore()
+	assert.NotNil(t, err)
+}
+
+func TestReorderBuffer(t *testing.T) {
+	tweet := func(id uint64, timestamp int64) *Tweet {
+		return &Tweet{ID: id, Timestamp: time.Unix(timestamp, 0)}
+	}
+
+	buffer := reorderBuffer{window: 2}
+	buffer.nextPage("first")
+	assert.Empty(t, buffer.push(tweet(1, 100)))
+	assert.Empty(t, buffer.push(tweet(2, 300)))
+	buffer.nextPage("second")
+	results := buffer.push(tweet(3, 200))
+	require.Equal(t, 1, len(results))
+	assert.Equal(t, uint64(2), results[0].Tweet.ID)
+	assert.Equal(t, "first", results[0].Position)
+
+	results = buffer.flush()
+	require.Equal(t, 2, len(results))
+	assert.Equal(t, uint64(3), results[0].Tweet.ID)
+	assert.Equal(t, "first", results[0].Position)
+	assert.Equal(t, uint64(1), results[1].Tweet.ID)
+	assert.Equal(t, "first", results[1].Position)
+
+	// Without a window tweets pass through unchanged.
+	buffer = reorderBuffer{}
+	buffer.nextPage("page")
+	results = buffer.push(tweet(1, 100))
+	require.Equal(t, 1, len(results))
+	assert.Equal(t, uint64(1), results[0].Tweet.ID)
+	assert.Empty(t, buffer.flush())
+}
+
+func TestFeedIterOrderByTimestamp(t *testing.T) {
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+
+	var tweets []*Tweet
+	for result := range session.FeedIter(OrderByTimestamp(50)) {
+		require.Nil(t, result.Error)
+		tweets = append(tweets, result.Tweet)
+	}
+	require.Equal(t, 20, len(tweets))
+	for i := 1; i < len(tweets); i++ {
+		assert.False(t, tweetIsNewer(tweets[i], tweets[i-1]))
+	}
+}
+
+func TestFeedIterBlocked(t *testing.T) {
+	pageJSON := func(itemsHTML, minPosition string) string {
+		data, _ := json.Marshal(map[string]interface{}{
+			"items_html":     itemsHTML,
+			"min_position":   minPosition,
+			"has_more_items": true,
+		})
+		return string(data)
+	}
+	var lastTermination TerminationReason
+	runIter := func(pages ...string) ([]FeedIterResult, int) {
+		requests := 0
+		client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+			if requests >= len(pages) {
+				assert.Fail(t, "Unexpected request: %s", r.URL.RequestURI())
+				return
+			}
+			fmt.Fprint(w, pages[requests])
+			requests++
+		})
+		defer server.Close()
+		session := NewTwitterSession(NewGenericFeedCursor("test", FeedTypeMedia))
+		session.cursor.(*GenericFeedCursor).client.httpClient = client
+
+		var results []FeedIterResult
+		for result := range session.FeedIter() {
+			results = append(results, result)
+		}
+		lastTermination = session.TerminationReason()
+		return results, requests
+	}
+	page, err := NewFeedPageFromJSON([]byte(readTextFileOrDie("testdata/items1.json")))
+	require.Nil(t, err)
+	itemsHTML, err := page.RawHTML()
+	require.Nil(t, err)
+
+	// The same min_position is returned for the position that was sent.
+	results, requests := runIter(pageJSON(itemsHTML, "100"), pageJSON(itemsHTML, "100"))
+	assert.Equal(t, 2, requests)
+	last := results[len(results)-1]
+	var blockedErr *BlockedError
+	require.True(t, errors.As(last.Error, &blockedErr))
+	assert.Equal(t, "100", blockedErr.Position())
+	assert.Equal(t, 20, len(results)-1)
+
+	// Pages claim to have more tweets, but are empty.
+	results, requests = runIter(pageJSON(itemsHTML, "100"),
+		pageJSON("", "99"), pageJSON("", "98"), pageJSON("", "97"))
+	assert.Equal(t, 4, requests)
+	last = results[len(results)-1]
+	require.True(t, errors.As(last.Error, &blockedErr))
+	assert.Equal(t, "98", blockedErr.Position())
+
+	// Positions oscillate between two pages.
+	results, requests = runIter(pageJSON(itemsHTML, "100"), pageJSON(itemsHTML, "200"),
+		pageJSON(itemsHTML, "100"))
+	assert.Equal(t, 3, requests)
+	last = results[len(results)-1]
+	var loopErr *PaginationLoopError
+	require.True(t, errors.As(last.Error, &loopErr))
+	assert.Equal(t, "100", loopErr.Position())
+	assert.Equal(t, TerminationError, lastTermination)
+
+	// A single empty page in between is skipped.
+	results, _ = runIter(pageJSON(itemsHTML, "100"), pageJSON("", "99"),
+		readTextFileOrDie("testdata/items1-repeated.json"), readTextFileOrDie("testdata/items4.json"))
+	for _, result := range results {
+		require.Nil(t, result.Error)
+	}
+	assert.Equal(t, 20, len(results))
+}
+
+func TestFeedIterDedupe(t *testing.T) {
+	countTweets := func(options ...FeedIterOption) int {
+		session, server := setupFixtureSession(t,
+			"testdata/items1.json", "testdata/items1-repeated.json", "testdata/items4.json")
+		defer server.Close()
+
+		count := 0
+		for result := range session.FeedIter(options...) {
+			require.Nil(t, result.Error)
+			count++
+		}
+		return count
+	}
+
+	assert.Equal(t, 20, countTweets())
+	assert.Equal(t, 40, countTweets(DisableDedupe()))
+}
+
+type mapSeenSet map[uint64]bool
+
+func (s mapSeenSet) Has(id uint64) bool { return s[id] }
+func (s mapSeenSet) Add(id uint64)      { s[id] = true }
+
+func TestSeenTweets(t *testing.T) {
+	seen := mapSeenSet{}
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+	WithSeenTweets(seen)(session)
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+	}
+	assert.Equal(t, 20, len(seen))
+	session.Close()
+	assert.Equal(t, 20, len(seen))
+
+	// Another session sharing the set emits nothing new.
+	session, server2 := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server2.Close()
+	WithSeenTweets(seen)(session)
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+		assert.Fail(t, "Unexpected tweet", "%d", result.Tweet.ID)
+	}
+	assert.Equal(t, int64(20), session.Stats().DuplicatesDropped)
+}
+
+func TestFeedIterPosition(t *testing.T) {
+	session, server := setupFixtureSession(t,
+		"testdata/items1.json", "testdata/items2.json", "testdata/items4.json")
+	defer server.Close()
+
+	positions := map[string]int{}
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+		positions[result.Position]++
+	}
+	assert.Equal(t, map[string]int{"": 20, "608164787940413441": 20}, positions)
+}
+
+func TestLiveRetrievalStatusCode(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+	defer server.Close()
+
+	cursor := NewGenericFeedCursor("test", FeedTypeMedia)
+	cursor.client.httpClient = client
+	_, err := cursor.RetrievePage()
+	require.NotNil(t, err)
+
+	var urlErr *URLError
+	require.True(t, errors.As(err, &urlErr))
+	assert.Equal(t, http.StatusTooManyRequests, urlErr.StatusCode())
+	assert.True(t, urlErr.IsRateLimited())
+	assert.False(t, urlErr.IsNotFound())
+}
+
+// extractSingleTweet parses HTML of a single stream item.
+func extractSingleTweet(t *testing.T, itemHTML string) *Tweet {
+	page := FeedPage{}
+	tweets, err := page.extractTweets(itemHTML)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tweets))
+	return tweets[0]
+}
+
+func TestPinnedTweetExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li class="js-stream-item stream-item js-pinned" data-item-type="tweet" data-item-id="1">
+			<div class="tweet user-pinned"><p class="tweet-text">Pinned</p></div>
+		</li>`)
+	assert.True(t, tweet.IsPinned)
+
+	tweet = extractSingleTweet(t, `
+		<li class="js-stream-item stream-item" data-item-type="tweet" data-item-id="1">
+			<div class="tweet"><p class="tweet-text">Regular</p></div>
+		</li>`)
+	assert.False(t, tweet.IsPinned)
+
+	// Marker on the tweet node follows overridden selectors.
+	page := FeedPage{}
+	page.SetSelectors(Selectors{Pinned: ".is-pinned"})
+	tweets, err := page.extractTweets(`
+		<li class="js-stream-item stream-item is-pinned" data-item-type="tweet" data-item-id="1">
+			<div class="tweet"><p class="tweet-text">Pinned</p></div>
+		</li>
+		<li class="js-stream-item stream-item js-pinned" data-item-type="tweet" data-item-id="2">
+			<div class="tweet"><p class="tweet-text">Old marker</p></div>
+		</li>`)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(tweets))
+	assert.True(t, tweets[0].IsPinned)
+	assert.False(t, tweets[1].IsPinned)
+}
+
+func TestGalleryAltTextExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Photos</p>
+			<div data-image-url="https://pbs.twimg.com/media/a.jpg"><img alt=" A cat "></div>
+			<div data-image-url="https://pbs.twimg.com/media/b.jpg"><img alt=""></div>
+		</li>`)
+	gallery, ok := tweet.Extra.(*TweetEmbeddedGallery)
+	require.True(t, ok)
+	assert.Equal(t, 2, len(gallery.ImageURLs))
+	assert.Equal(t, []string{"A cat", ""}, gallery.AltTexts)
+
+	tweet = extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Photo</p>
+			<div data-image-url="https://pbs.twimg.com/media/a.jpg"><img alt=""></div>
+		</li>`)
+	gallery, ok = tweet.Extra.(*TweetEmbeddedGallery)
+	require.True(t, ok)
+	assert.Nil(t, gallery.AltTexts)
+	assert.Nil(t, gallery.Sizes)
+}
+
+func TestGallerySizeExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Photos</p>
+			<div data-image-url="https://pbs.twimg.com/media/a.jpg" data-width="1200" data-height="675"></div>
+			<div data-image-url="https://pbs.twimg.com/media/b.jpg"><img width="640" height="480"></div>
+			<div data-image-url="https://pbs.twimg.com/media/c.jpg"><img width="640"></div>
+		</li>`)
+	gallery, ok := tweet.Extra.(*TweetEmbeddedGallery)
+	require.True(t, ok)
+	assert.Equal(t, []ImageSize{{1200, 675}, {640, 480}, {}}, gallery.Sizes)
+}
+
+func TestLocationExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Hello</p>
+			<span class="Tweet-geo u-floatRight js-tooltip" title="Manhattan, NY">
+				<a class="js-geo-pivot-link" href="/search?q=place%3A01a9a39529b27f36"></a>
+			</span>
+		</li>`)
+	require.NotNil(t, tweet.Location)
+	assert.Equal(t, TweetLocation{"Manhattan, NY", "01a9a39529b27f36"}, *tweet.Location)
+
+	tweet = extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Hello</p>
+			<a class="tweet-geo-text" href="/places/5a110d312052166f"> San Francisco, CA </a>
+		</li>`)
+	require.NotNil(t, tweet.Location)
+	assert.Equal(t, TweetLocation{"San Francisco, CA", "5a110d312052166f"}, *tweet.Location)
+
+	tweet = extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1"><p class="tweet-text">Hello</p></li>`)
+	assert.Nil(t, tweet.Location)
+}
+
+func TestSourceExtraction(t *testing.T) {
+	sources := map[string]string{
+		`<span class="tweet-source">via <a href="https://about.twitter.com" rel="nofollow">Twitter for iPhone</a></span>`: "Twitter for iPhone",
+		`<span class="tweet-source">via TweetDeck</span>`:                                                                 "TweetDeck",
+		`<div data-source=" Twitter Web Client "></div>`:                                                                  "Twitter Web Client",
+		``: "",
+	}
+	for markup, expected := range sources {
+		tweet := extractSingleTweet(t, `
+			<li data-item-type="tweet" data-item-id="1">
+				<p class="tweet-text">Hello</p>`+markup+`
+			</li>`)
+		assert.Equal(t, expected, tweet.Source, markup)
+	}
+}
+
+func TestAuthorVerifiedExtraction(t *testing.T) {
+	badges := map[string]bool{
+		`<span class="UserBadges"><span class="Icon Icon--verified"></span></span>`:  true,
+		`<span class="UserBadges"><svg aria-label="Verified account"></svg></span>`:  true,
+		`<span><svg data-testid="icon-verified"></svg></span>`:                       true,
+		`<span class="UserBadges"><span class="Icon Icon--protected"></span></span>`: false,
+		``: false,
+	}
+	for markup, expected := range badges {
+		tweet := extractSingleTweet(t, `
+			<li data-item-type="tweet" data-item-id="1">
+				<div class="stream-item-header">`+markup+`</div>
+				<p class="tweet-text">Hello</p>
+			</li>`)
+		assert.Equal(t, expected, tweet.AuthorVerified, markup)
+	}
+
+	// Badge of a quoted tweet's author doesn't count.
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Hello</p>
+			<div class="QuoteTweet"><span class="Icon Icon--verified"></span></div>
+		</li>`)
+	assert.False(t, tweet.AuthorVerified)
+
+	page := FeedPage{}
+	tweets, err := page.extractTweets(readTextFileOrDie("testdata/items1.html"))
+	require.Nil(t, err)
+	assert.True(t, tweets[0].AuthorVerified)
+}
+
+func TestSensitiveExtraction(t *testing.T) {
+	markups := map[string]bool{
+		`<div class="tweet" data-possibly-sensitive="true"><p class="tweet-text">Hi</p></div>`:       true,
+		`<div class="tweet" data-possibly-sensitive="false"><p class="tweet-text">Hi</p></div>`:      false,
+		`<p class="tweet-text">Hi</p><div class="AdaptiveMedia is-sensitive"></div>`:                 true,
+		`<p class="tweet-text">Hi</p><div class="QuoteTweet"><div class="is-sensitive"></div></div>`: false,
+		`<p class="tweet-text">Hi</p>`: false,
+	}
+	for markup, expected := range markups {
+		tweet := extractSingleTweet(t, `<li data-item-type="tweet" data-item-id="1">`+markup+`</li>`)
+		assert.Equal(t, expected, tweet.Sensitive, markup)
+	}
+}
+
+func TestEntityExtraction(t *testing.T) {
+	const itemHTML = `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Hi <a href="/someone" class="twitter-atreply">@<b>someone</b></a>
+			<a href="/hashtag/Go?src=hash" class="twitter-hashtag">#<b>Go</b></a>
+			<a href="https://t.co/x" class="twitter-timeline-link" data-expanded-url="https://golang.org/">golang.org</a>
+			<a href="https://t.co/y" class="twitter-timeline-link u-hidden">pic.twitter.com/y</a></p>
+		</li>`
+	tweet := extractSingleTweet(t, itemHTML)
+	assert.Equal(t, []string{"Go"}, tweet.Hashtags)
+	assert.Equal(t, []string{"someone"}, tweet.Mentions)
+	assert.Equal(t, []string{"https://golang.org/"}, tweet.URLs)
+
+	// Structured entities win over the markup.
+	page := NewFeedPage(map[string]interface{}{
+		"items_html": itemHTML,
+		"entities": map[string]interface{}{
+			"1": map[string]interface{}{
+				"hashtags":      []interface{}{map[string]interface{}{"text": "golang"}},
+				"user_mentions": []interface{}{map[string]interface{}{"screen_name": "gopher"}},
+			},
+		},
+	})
+	tweets, err := page.GetTweets()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tweets))
+	assert.Equal(t, []string{"golang"}, tweets[0].Hashtags)
+	assert.Equal(t, []string{"gopher"}, tweets[0].Mentions)
+	assert.Nil(t, tweets[0].URLs)
+}
+
+func TestReplyExtraction(t *testing.T) {
+	page := FeedPage{}
+	tweets, err := page.extractTweets(readTextFileOrDie("testdata/items1.html"))
+	require.Nil(t, err)
+
+	byID := map[uint64]*Tweet{}
+	for _, tweet := range tweets {
+		byID[tweet.ID] = tweet
+	}
+
+	reply := byID[969312569734307840]
+	require.NotNil(t, reply)
+	assert.True(t, reply.IsReply())
+	assert.Equal(t, uint64(969301303682727937), reply.ConversationID)
+	assert.Equal(t, []string{"RobertDowneyJr", "MarvelStudios"}, reply.InReplyToUsernames)
+	assert.Equal(t, "fr", reply.Lang)
+	assert.Equal(t, "Twitter", reply.Username)
+	assert.Equal(t, "1519937100", reply.RawTime)
+	assert.Equal(t, int64(1519937100), reply.Timestamp.Unix())
+
+	standalone := byID[991826226405818368]
+	require.NotNil(t, standalone)
+	assert.False(t, standalone.IsReply())
+	assert.Empty(t, standalone.InReplyToUsernames)
+	assert.Equal(t, standalone.ID, standalone.ConversationID)
+}
+
+func TestFeedIterLanguages(t *testing.T) {
+	countTweets := func(options ...FeedIterOption) int {
+		session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+		defer server.Close()
+
+		count := 0
+		for result := range session.FeedIter(options...) {
+			require.Nil(t, result.Error)
+			count++
+		}
+		return count
+	}
+
+	all := countTweets()
+	english := countTweets(Languages("EN"))
+	assert.Equal(t, 20, all)
+	assert.True(t, english > 0 && english < all)
+	assert.Equal(t, all, countTweets(Languages()))
+	assert.Equal(t, 0, countTweets(Languages("xx")))
+
+	assert.True(t, matchesLanguage("es-419", []string{"es"}))
+	assert.False(t, matchesLanguage("est", []string{"es"}))
+}
+
+func TestFeedIterFilter(t *testing.T) {
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+
+	filtered := 0
+	for result := range session.FeedIter(Filter(HasMedia), Filter(func(tweet *Tweet) bool {
+		return tweet.ID != 997211099652030464
+	})) {
+		require.Nil(t, result.Error)
+		assert.True(t, HasMedia(result.Tweet))
+		assert.NotEqual(t, uint64(997211099652030464), result.Tweet.ID)
+		filtered++
+	}
+	assert.True(t, filtered > 0)
+}
+
+func TestRetweetExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="2">
+			<div class="tweet" data-retweet-id="2" data-retweeter="test"><p class="tweet-text">RT</p></div>
+		</li>`)
+	assert.True(t, tweet.IsRetweet)
+	assert.False(t, ExcludeRetweets(tweet))
+}
+
+func TestCardExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Card</p>
+			<div class="card2" data-card-url="https://t.co/card">
+				<img src="https://pbs.twimg.com/card_img/1/preview.jpg">
+			</div>
+		</li>`)
+	require.IsType(t, &TweetEmbeddedCard{}, tweet.Extra)
+	card := tweet.Extra.(*TweetEmbeddedCard)
+	assert.Equal(t, "https://t.co/card", card.CardURL)
+	assert.Equal(t, "https://pbs.twimg.com/card_img/1/preview.jpg", card.ImageURL)
+
+	_, _, err := (&TweetEmbeddedCard{CardURL: "https://t.co/card"}).Download()
+	assert.NotNil(t, err)
+}
+
+func TestPollExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Poll</p>
+			<div class="card2" data-card2-name="poll2choice_text_only" data-card-url="https://t.co/poll">
+				<div class="PollXChoice is-final" data-end-time="1525304774">
+					<div class="PollXChoice-choice">
+						<span class="PollXChoice-choice--text">Yes</span>
+						<span class="PollXChoice-progress">62.5%</span>
+					</div>
+					<div class="PollXChoice-choice" data-votes="3">
+						<span class="PollXChoice-choice--text">No</span>
+						<span class="PollXChoice-progress">37.5%</span>
+					</div>
+				</div>
+			</div>
+		</li>`)
+	require.IsType(t, &TweetEmbeddedPoll{}, tweet.Extra)
+	poll := tweet.Extra.(*TweetEmbeddedPoll)
+	assert.Equal(t, []PollOption{{"Yes", 0, 62.5}, {"No", 3, 37.5}}, poll.Options)
+	assert.Equal(t, time.Unix(1525304774, 0), poll.EndsAt)
+	assert.True(t, poll.Finished)
+	assert.Equal(t, "EMBED_TYPE_POLL", tweet.EmbedType())
+
+	// Polls in progress have no final marker.
+	tweet = extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Poll</p>
+			<div class="card2" data-card2-name="poll3choice_text_only">
+				<div class="PollXChoice-choice" data-percent="100"><span class="PollXChoice-choice--text">A</span></div>
+			</div>
+		</li>`)
+	require.IsType(t, &TweetEmbeddedPoll{}, tweet.Extra)
+	poll = tweet.Extra.(*TweetEmbeddedPoll)
+	assert.Equal(t, []PollOption{{"A", 0, 100}}, poll.Options)
+	assert.True(t, poll.EndsAt.IsZero())
+	assert.False(t, poll.Finished)
+
+	// Markers of closed polls can be overridden.
+	page := FeedPage{}
+	page.SetSelectors(Selectors{PollFinished: ".PollXChoice-choice"})
+	tweets, err := page.extractTweets(`
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Poll</p>
+			<div class="card2" data-card2-name="poll3choice_text_only">
+				<div class="PollXChoice-choice" data-percent="100"><span class="PollXChoice-choice--text">A</span></div>
+			</div>
+		</li>`)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tweets))
+	assert.True(t, tweets[0].Extra.(*TweetEmbeddedPoll).Finished)
+}
+
+func TestQuoteExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Look</p>
+			<div class="QuoteTweet">
+				<div class="QuoteTweet-link" href="/other/status/2"></div>
+				<b class="QuoteTweet-fullname"> Other User </b>
+				<div class="QuoteTweet-text tweet-text">Quoted text</div>
+			</div>
+		</li>`)
+	require.IsType(t, &TweetEmbeddedQuote{}, tweet.Extra)
+	assert.Equal(t, &TweetEmbeddedQuote{
+		"https://twitter.com/other/status/2", "Quoted text", "Other User",
+	}, tweet.Extra)
+	assert.Equal(t, "Look", tweet.Text)
+
+	// Missing text and name leave the fields empty.
+	tweet = extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Look</p>
+			<div class="QuoteTweet-link" href="/other/status/2"></div>
+		</li>`)
+	assert.Equal(t, &TweetEmbeddedQuote{QuoteURL: "https://twitter.com/other/status/2"}, tweet.Extra)
+}
+
+func TestGIFExtraction(t *testing.T) {
+	tweet := extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">GIF</p>
+			<div class="PlayableMedia PlayableMedia--gif">
+				<div class="PlayableMedia-player"
+					style="padding-bottom: 56.25%; background-image:url('https://pbs.twimg.com/tweet_video_thumb/DcKx2.jpg')">
+				</div>
+			</div>
+		</li>`)
+	require.IsType(t, &TweetEmbeddedGIF{}, tweet.Extra)
+	gif := tweet.Extra.(*TweetEmbeddedGIF)
+	assert.Equal(t, "https://video.twimg.com/tweet_video/DcKx2.mp4", gif.VideoURL)
+	assert.Equal(t, "https://pbs.twimg.com/tweet_video_thumb/DcKx2.jpg", gif.ThumbnailURL)
+	assert.True(t, tweet.HasMedia())
+
+	// Players of regular videos aren't GIFs.
+	tweet = extractSingleTweet(t, `
+		<li data-item-type="tweet" data-item-id="1">
+			<p class="tweet-text">Video</p>
+			<div class="PlayableMedia PlayableMedia--video">
+				<div class="PlayableMedia-player"
+					style="background-image:url('https://pbs.twimg.com/ext_tw_video_thumb/1/pu/img/a.jpg')">
+				</div>
+			</div>
+		</li>`)
+	assert.Nil(t, tweet.Extra)
+
+	assert.Equal(t, "", gifVideoURL("https://pbs.twimg.com/media/a.jpg"))
+	_, _, err := (&TweetEmbeddedGIF{}).Download()
+	assert.NotNil(t, err)
+}
+
+func TestSearchIDRange(t *testing.T) {
+	var query, referrer string
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query().Get("q")
+			referrer = r.Header.Get("Referer")
+			fmt.Fprint(w, readTextFileOrDie("testdata/items4.json"))
+		}))
+	defer server.Close()
+
+	cursor := NewSearchFeedCursor("from:test")
+	cursor.client.httpClient = client
+
+	_, err := cursor.RetrievePage()
+	require.Nil(t, err)
+	assert.Equal(t, "from:test", query)
+
+	cursor.SetIDRange(100, 200)
+	_, err = cursor.RetrievePage()
+	require.Nil(t, err)
+	assert.Equal(t, "from:test since_id:100 max_id:200", query)
+	assert.Equal(t, "https://twitter.com/search?q=from%3Atest+since_id%3A100+max_id%3A200", referrer)
+
+	cursor.SetIDRange(0, 200)
+	_, err = cursor.RetrievePage()
+	require.Nil(t, err)
+	assert.Equal(t, "from:test max_id:200", query)
+}
+
+func TestSearchMode(t *testing.T) {
+	var params url.Values
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params = r.URL.Query()
+			fmt.Fprint(w, readTextFileOrDie("testdata/items4.json"))
+		}))
+	defer server.Close()
+
+	cursor := NewSearchFeedCursor("from:test")
+	cursor.client.httpClient = client
+	modes := map[SearchMode]string{
+		SearchTop:    "",
+		SearchLatest: "tweets",
+		SearchPhotos: "images",
+		SearchVideos: "videos",
+	}
+	for mode, filter := range modes {
+		cursor.SetMode(mode)
+		_, err := cursor.RetrievePage()
+		require.Nil(t, err)
+		assert.Equal(t, filter, params.Get("f"))
+		assert.Equal(t, "default", params.Get("vertical"))
+		assert.Equal(t, "from:test", params.Get("q"))
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	var path, referrer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		referrer = r.Header.Get("Referer")
+		fmt.Fprint(w, readTextFileOrDie("testdata/items1.json"))
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL + "/mirror/")
+	require.Nil(t, err)
+	client := NewTwitterHTTP(WithBaseURL(baseURL))
+
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	cursor.SetClient(client)
+	page, err := cursor.RetrievePage()
+	require.Nil(t, err)
+	tweets, err := page.GetTweets()
+	require.Nil(t, err)
+	assert.NotEmpty(t, tweets)
+	assert.Equal(t, "/mirror/i/profiles/show/test/timeline", path)
+	assert.Equal(t, server.URL+"/mirror/test", referrer)
+
+	search := NewSearchFeedCursor("from:test")
+	search.SetClient(client)
+	_, err = search.RetrievePage()
+	require.Nil(t, err)
+	assert.Equal(t, "/mirror/i/search/timeline", path)
+	assert.Equal(t, server.URL+"/mirror/search?q=from%3Atest", referrer)
+}
+
+func TestCursorDirectionNewer(t *testing.T) {
+	var positions []string
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.URL.Query().Get("max_position"))
+		position := r.URL.Query().Get("min_position")
+		positions = append(positions, position)
+		page := map[string]interface{}{"items_html": "", "max_position": position}
+		if position == "100" {
+			page["items_html"] = `<li data-item-type="tweet" data-item-id="150"><p class="tweet-text">New</p></li>`
+			page["max_position"] = "150"
+			page["has_more_items"] = false
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	defer server.Close()
+
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular, "100")
+	cursor.client.httpClient = client
+	cursor.SetDirection(DirectionNewer)
+
+	var ids []uint64
+	for result := range NewTwitterSession(cursor).FeedIter() {
+		require.Nil(t, result.Error)
+		ids = append(ids, result.Tweet.ID)
+	}
+	assert.Equal(t, []uint64{150}, ids)
+	assert.Equal(t, []string{"100", "150"}, positions)
+	assert.Equal(t, "150", cursor.Position())
+}
+
+func TestFeedTypeRequests(t *testing.T) {
+	var path, referrer string
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			referrer = r.Header.Get("Referer")
+			fmt.Fprint(w, readTextFileOrDie("testdata/items4.json"))
+		}))
+	defer server.Close()
+
+	expected := map[FeedFilter][2]string{
+		FeedTypeRegular:     {"/i/profiles/show/test/timeline", "https://twitter.com/test"},
+		FeedTypeMedia:       {"/i/profiles/show/test/media_timeline", "https://twitter.com/test/media"},
+		FeedTypeWithReplies: {"/i/profiles/show/test/timeline/with_replies", "https://twitter.com/test/with_replies"},
+		FeedTypeLikes:       {"/test/likes/timeline", "https://twitter.com/test/likes"},
+	}
+	for feedType, request := range expected {
+		cursor := NewGenericFeedCursor("test", feedType)
+		cursor.client.httpClient = client
+		_, err := cursor.RetrievePage()
+		require.Nil(t, err)
+		assert.Equal(t, request[0], path)
+		assert.Equal(t, request[1], referrer)
+	}
+}
+
+func TestFeedIterEmptyFeed(t *testing.T) {
+	lastError := func(filename string) error {
+		session, server := setupFixtureSession(t, filename)
+		defer server.Close()
+
+		var err error
+		for result := range session.FeedIter() {
+			err = result.Error
+		}
+		return err
+	}
+
+	assert.Equal(t, ErrEmptyFeed, lastError("testdata/items4.json"))
+
+	var compatErr *APICompatError
+	assert.True(t, errors.As(lastError("testdata/markup-drift.json"), &compatErr))
+}
+
+func TestAPICompatErrorDetails(t *testing.T) {
+	page := FeedPage{}
+	tweets, err := page.extractTweets(`<li data-item-type="tweet" data-item-id="7"><p>No text</p></li>`)
+	assert.Empty(t, tweets)
+
+	var compatErr *APICompatError
+	require.True(t, errors.As(err, &compatErr))
+	assert.Equal(t, "p.tweet-text", compatErr.Selector())
+	assert.Contains(t, compatErr.Snippet(), `data-item-id="7"`)
+	require.NotNil(t, compatErr.TwitterID())
+	assert.Equal(t, uint64(7), *compatErr.TwitterID())
+}
+
+func BenchmarkFeedPage(b *testing.B) {
+	var items map[string]interface{}
+	if err := json.Unmarshal([]byte(readTextFileOrDie("testdata/items1.json")), &items); err != nil {
+		b.Fatal(err)
+	}
+	// Make GetMinPosition() extract the position from HTML.
+	delete(items, "min_position")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page := NewFeedPage(items)
+		if _, err := page.GetTweets(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := page.GetMinPosition(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package rattler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	gq "github.com/PuerkitoBio/goquery"
+)
+
+// Profile holds public information about a Twitter account.
+type Profile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	Bio         string    `json:"bio"`
+	Tweets      int       `json:"tweets"`
+	Followers   int       `json:"followers"`
+	Following   int       `json:"following"`
+	Verified    bool      `json:"verified"`
+	Protected   bool      `json:"protected"`
+	JoinDate    time.Time `json:"joinDate"`
+}
+
+// FetchProfile retrieves profile information of the given user.
+//
+// If the account doesn't exist or has been suspended, the returned error is
+// an *AccountStateError.
+func (t *TwitterHTTP) FetchProfile(username string) (*Profile, error) {
+	if !isValidUsername(username) {
+		return nil, &InvalidUsernameError{username}
+	}
+
+	aURL := t.endpoint("/"+username, nil)
+
+	request, err := t.newRequest(aURL)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.do(request)
+	if err != nil {
+		var urlErr *URLError
+		if errors.As(err, &urlErr) && urlErr.IsNotFound() {
+			return nil, &AccountStateError{username, AccountNotFound}
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	// Suspended accounts are redirected to a page explaining the suspension.
+	if strings.HasPrefix(response.Request.URL.Path, "/account/suspended") {
+		return nil, &AccountStateError{username, AccountSuspended}
+	}
+
+	doc, err := gq.NewDocumentFromReader(response.Body)
+	if err != nil {
+		return nil, &URLError{msg: "Unable to parse profile HTML", url: aURL.String(), cause: err}
+	}
+	return extractProfile(username, doc.Selection)
+}
+
+// extractProfile extracts profile information from the profile page.
+func extractProfile(username string, sel *gq.Selection) (*Profile, error) {
+	headerSel := sel.Find("div.ProfileHeaderCard")
+	if headerSel.Length() == 0 {
+		return nil, newAPICompatError("Profile header not found", "div.ProfileHeaderCard", sel, nil)
+	}
+
+	profile := &Profile{
+		Username:    username,
+		DisplayName: strings.TrimSpace(headerSel.Find("a.ProfileHeaderCard-nameLink").Text()),
+		Bio:         strings.TrimSpace(headerSel.Find("p.ProfileHeaderCard-bio").Text()),
+		Verified:    headerSel.Find(".Icon--verified").Length() > 0,
+		Protected:   headerSel.Find(".Icon--protected").Length() > 0,
+	}
+
+	counters := []struct {
+		selector string
+		value    *int
+	}{
+		{"li.ProfileNav-item--tweets", &profile.Tweets},
+		{"li.ProfileNav-item--followers", &profile.Followers},
+		{"li.ProfileNav-item--following", &profile.Following},
+	}
+	for _, counter := range counters {
+		countSel := sel.Find(counter.selector + " [data-count]")
+		if countSel.Length() == 0 {
+			// Counters are hidden if they are zero or the account is protected.
+			continue
+		}
+		count, err := strconv.Atoi(countSel.First().AttrOr("data-count", ""))
+		if err != nil {
+			msg := "Unable to parse profile counter: " + err.Error()
+			return nil, newAPICompatError(msg, counter.selector, countSel, nil)
+		}
+		*counter.value = count
+	}
+
+	joinDateSel := headerSel.Find(".ProfileHeaderCard-joinDateText")
+	if title, exists := joinDateSel.Attr("title"); exists {
+		if date, err := time.Parse("3:04 PM - 2 Jan 2006", title); err == nil {
+			profile.JoinDate = date
+		}
+	}
+	if profile.JoinDate.IsZero() {
+		text := strings.TrimPrefix(strings.TrimSpace(joinDateSel.Text()), "Joined ")
+		if date, err := time.Parse("January 2006", text); err == nil {
+			profile.JoinDate = date
+		}
+	}
+
+	return profile, nil
+}
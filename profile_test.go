@@ -0,0 +1,116 @@
+package rattler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProfileHTML = `
+<html><body>
+<div class="ProfileHeaderCard">
+	<h1><a class="ProfileHeaderCard-nameLink" href="/test">Test Account</a></h1>
+	<span class="ProfileHeaderCard-badges"><span class="Icon Icon--verified"></span></span>
+	<p class="ProfileHeaderCard-bio">Just testing.</p>
+	<span class="ProfileHeaderCard-joinDateText" title="10:31 AM - 9 Jan 2009">Joined January 2009</span>
+</div>
+<ul>
+	<li class="ProfileNav-item ProfileNav-item--tweets"><span class="ProfileNav-value" data-count="1234">1.2K</span></li>
+	<li class="ProfileNav-item ProfileNav-item--following"><span class="ProfileNav-value" data-count="56">56</span></li>
+	<li class="ProfileNav-item ProfileNav-item--followers"><span class="ProfileNav-value" data-count="7890">7.8K</span></li>
+</ul>
+</body></html>`
+
+func TestFetchProfile(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/test":
+				fmt.Fprint(w, testProfileHTML)
+			case "/suspended":
+				http.Redirect(w, r, "/account/suspended", http.StatusFound)
+			case "/account/suspended":
+				fmt.Fprint(w, "<html></html>")
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer server.Close()
+
+	twitterHTTP := NewTwitterHTTP()
+	twitterHTTP.httpClient = client
+
+	profile, err := twitterHTTP.FetchProfile("test")
+	require.Nil(t, err)
+	assert.Equal(t, &Profile{
+		Username:    "test",
+		DisplayName: "Test Account",
+		Bio:         "Just testing.",
+		Tweets:      1234,
+		Followers:   7890,
+		Following:   56,
+		Verified:    true,
+		JoinDate:    time.Date(2009, 1, 9, 10, 31, 0, 0, time.UTC),
+	}, profile)
+
+	var stateErr *AccountStateError
+	_, err = twitterHTTP.FetchProfile("missing")
+	require.True(t, errors.As(err, &stateErr))
+	assert.Equal(t, AccountNotFound, stateErr.State())
+
+	_, err = twitterHTTP.FetchProfile("suspended")
+	require.True(t, errors.As(err, &stateErr))
+	assert.Equal(t, AccountSuspended, stateErr.State())
+}
+
+func TestCursorAccountState(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/protected":
+				fmt.Fprint(w, strings.Replace(testProfileHTML, "Icon--verified", "Icon--protected", 1))
+			case "/suspended":
+				http.Redirect(w, r, "/account/suspended", http.StatusFound)
+			case "/account/suspended":
+				fmt.Fprint(w, "<html></html>")
+			case "/i/profiles/show/suspended/timeline":
+				http.Redirect(w, r, "/account/suspended", http.StatusFound)
+			case "/i/profiles/show/protected/timeline":
+				w.WriteHeader(http.StatusForbidden)
+			case "/i/profiles/show/flaky/timeline":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer server.Close()
+
+	retrieve := func(username string) error {
+		cursor := NewGenericFeedCursor(username, FeedTypeRegular)
+		cursor.client.httpClient = client
+		_, err := cursor.RetrievePage()
+		return err
+	}
+
+	for username, state := range map[string]AccountState{
+		"missing":   AccountNotFound,
+		"suspended": AccountSuspended,
+		"protected": AccountProtected,
+	} {
+		var stateErr *AccountStateError
+		err := retrieve(username)
+		require.True(t, errors.As(err, &stateErr), "Unexpected error for %s: %v", username, err)
+		assert.Equal(t, state, stateErr.State())
+		assert.Equal(t, username, stateErr.Username())
+	}
+
+	var urlErr *URLError
+	require.True(t, errors.As(retrieve("flaky"), &urlErr))
+	assert.Equal(t, http.StatusInternalServerError, urlErr.StatusCode())
+}
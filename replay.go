@@ -0,0 +1,220 @@
+package rattler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// replayTransport serves responses from fixture files stored in a directory
+// instead of making network requests.
+type replayTransport struct {
+	dir string
+}
+
+// NewTwitterHTTPFromDir creates TwitterHTTP that reads responses from
+// fixture files in dir instead of hitting the network.
+//
+// The fixture for a request is named after the request path with slashes
+// replaced by underscores (e.g. "i_profiles_show_github_timeline"). Requests
+// for subsequent pages carry "@<max_position>" suffix. Requests with a query
+// string carry "~<hash>" suffix as well, a hash of the whole sorted query, so
+// that requests differing in other parameters (e.g. search query or
+// min_position) don't share a fixture. A fixture without the hash suffix is
+// used for any query if no fixture with it exists, which is convenient for
+// fixtures written by hand. The following extensions are tried in order:
+//
+//	<name>.deflate   - served with "Content-Encoding: deflate"
+//	<name>.gz        - served with "Content-Encoding: gzip"
+//	<name>.json.gz   - gzip-compressed JSON
+//	<name>.html.gz   - gzip-compressed HTML
+//	<name>.json
+//	<name>.html
+//	<name>
+//
+// A request without a matching fixture receives 404 response. Fixtures can be
+// recorded with WithCapture().
+func NewTwitterHTTPFromDir(dir string, options ...HTTPOption) *TwitterHTTP {
+	client := NewTwitterHTTP(options...)
+	client.setTransport(&replayTransport{dir})
+	return client
+}
+
+// fixtureName returns the name of the fixture file for given request without
+// extension.
+func fixtureName(request *http.Request) string {
+	name := fixtureBaseName(request)
+	if query := request.URL.Query(); len(query) > 0 {
+		// Encode() sorts parameters by name.
+		hash := sha1.Sum([]byte(query.Encode()))
+		name += "~" + hex.EncodeToString(hash[:6])
+	}
+	return name
+}
+
+// fixtureBaseName returns the name of the fixture file for given request
+// without the query hash and extension.
+func fixtureBaseName(request *http.Request) string {
+	name := strings.Trim(request.URL.Path, "/")
+	if len(name) == 0 {
+		name = "index"
+	}
+	name = strings.Replace(name, "/", "_", -1)
+	if position := request.URL.Query().Get(olderPositionParam); len(position) > 0 {
+		name += "@" + position
+	}
+	return name
+}
+
+func (t *replayTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+
+	for _, name := range []string{fixtureName(request), fixtureBaseName(request)} {
+		response, err := t.replay(request, filepath.Join(t.dir, name))
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+	return newReplayResponse(request, http.StatusNotFound, nil), nil
+}
+
+// replay serves the fixture with given path and no extension. Returns nil
+// response if there's no such fixture.
+func (t *replayTransport) replay(request *http.Request, name string) (*http.Response, error) {
+	candidates := []struct {
+		ext         string
+		contentType string
+		encoding    string
+	}{
+		{".deflate", "", "deflate"},
+		{".gz", "", "gzip"},
+		{".json.gz", "application/json", "gzip"},
+		{".html.gz", "text/html", "gzip"},
+		{".json", "application/json", ""},
+		{".html", "text/html", ""},
+		{"", "", ""},
+	}
+	for _, candidate := range candidates {
+		data, err := ioutil.ReadFile(name + candidate.ext)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		response := newReplayResponse(request, http.StatusOK, data)
+		if len(candidate.contentType) > 0 {
+			response.Header.Set("Content-Type", candidate.contentType)
+		}
+		if len(candidate.encoding) > 0 {
+			response.Header.Set("Content-Encoding", candidate.encoding)
+		}
+		return response, nil
+	}
+	return nil, nil
+}
+
+// captureTransport stores bodies of successful responses as fixture files
+// understood by replayTransport.
+type captureTransport struct {
+	dir      string
+	compress bool
+	next     http.RoundTripper
+}
+
+// WithCapture makes the client store the raw body of every successful
+// response in dir, named the way NewTwitterHTTPFromDir() expects. Captured
+// session can then be replayed, e.g. to debug a parsing problem without
+// access to the original account.
+//
+// If dir has ".gz" extension, uncompressed responses are stored gzipped with
+// ".gz" appended to their extension (e.g. "<name>.json.gz"), which keeps
+// captured sessions small. Responses that were compressed by the server are
+// stored as is.
+//
+// Failure to store a response fails the request.
+func WithCapture(dir string) HTTPOption {
+	return func(t *TwitterHTTP) {
+		compress := filepath.Ext(filepath.Clean(dir)) == ".gz"
+		t.wrapTransport(func(next http.RoundTripper) http.RoundTripper {
+			if next == nil {
+				next = http.DefaultTransport
+			}
+			return &captureTransport{dir, compress, next}
+		})
+	}
+}
+
+func (t *captureTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.next.RoundTrip(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return response, err
+	}
+
+	data, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	ext := captureExt(response)
+	fixture := data
+	if t.compress && len(response.Header.Get("Content-Encoding")) == 0 {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(data)
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+		fixture = compressed.Bytes()
+		ext += ".gz"
+	}
+
+	name := filepath.Join(t.dir, fixtureName(request)+ext)
+	if err = ioutil.WriteFile(name, fixture, 0644); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// captureExt returns extension of the fixture file for given response.
+func captureExt(response *http.Response) string {
+	switch strings.ToLower(response.Header.Get("Content-Encoding")) {
+	case "deflate":
+		return ".deflate"
+	case "gzip", "x-gzip":
+		return ".gz"
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	switch {
+	case strings.Contains(contentType, "json"):
+		return ".json"
+	case strings.Contains(contentType, "html"):
+		return ".html"
+	}
+	return ""
+}
+
+func newReplayResponse(request *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
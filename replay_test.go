@@ -0,0 +1,149 @@
+package rattler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rattler-replay")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	copyFixture := func(src, dst string) {
+		data := readTextFileOrDie(src)
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, dst), []byte(data), 0644))
+	}
+	copyFixture("testdata/items1.json", "i_profiles_show_test_media_timeline.json")
+	copyFixture("testdata/items2.json", "i_profiles_show_test_media_timeline@608164787940413441.json")
+
+	// Serve the last page from gzipped JSON.
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write([]byte(readTextFileOrDie("testdata/items4.json")))
+	gzipWriter.Close()
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "i_profiles_show_test_media_timeline@506859703859965952.json.gz"),
+		gzipped.Bytes(), 0644))
+
+	// Serve the second page compressed.
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write([]byte(readTextFileOrDie("testdata/items2.json")))
+	writer.Close()
+	require.Nil(t, os.Remove(filepath.Join(dir, "i_profiles_show_test_media_timeline@608164787940413441.json")))
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "i_profiles_show_test_media_timeline@608164787940413441.deflate"),
+		compressed.Bytes(), 0644))
+
+	cursor := NewGenericFeedCursor("test", FeedTypeMedia)
+	cursor.SetClient(NewTwitterHTTPFromDir(dir))
+	session := NewTwitterSession(cursor)
+
+	count := 0
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+		count++
+	}
+	assert.Equal(t, 40, count)
+}
+
+func TestCaptureThenReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rattler-capture")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+	WithCapture(dir)(session.cursor.(*GenericFeedCursor).client)
+
+	var captured []uint64
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+		captured = append(captured, result.Tweet.ID)
+	}
+	require.Equal(t, 20, len(captured))
+
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(files))
+
+	cursor := NewGenericFeedCursor("test", FeedTypeMedia)
+	cursor.SetClient(NewTwitterHTTPFromDir(dir))
+	var replayed []uint64
+	for result := range NewTwitterSession(cursor).FeedIter() {
+		require.Nil(t, result.Error)
+		replayed = append(replayed, result.Tweet.ID)
+	}
+	assert.Equal(t, captured, replayed)
+}
+
+func TestCompressedCaptureThenReplay(t *testing.T) {
+	root, err := ioutil.TempDir("", "rattler-capture")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "session.gz")
+	require.Nil(t, os.Mkdir(dir, 0755))
+
+	session, server := setupFixtureSession(t, "testdata/items1.json", "testdata/items4.json")
+	defer server.Close()
+	WithCapture(dir)(session.cursor.(*GenericFeedCursor).client)
+
+	var captured []uint64
+	for result := range session.FeedIter() {
+		require.Nil(t, result.Error)
+		captured = append(captured, result.Tweet.ID)
+	}
+	require.Equal(t, 20, len(captured))
+
+	// Fixture server sends no JSON content type, so the fixture has no
+	// extension other than ".gz".
+	names, err := filepath.Glob(filepath.Join(dir, "i_profiles_show_test_media_timeline~*.gz"))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(names))
+	data, err := ioutil.ReadFile(names[0])
+	require.Nil(t, err)
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	require.Nil(t, err)
+	uncompressed, err := ioutil.ReadAll(reader)
+	require.Nil(t, err)
+	assert.Equal(t, readTextFileOrDie("testdata/items1.json"), string(uncompressed))
+
+	cursor := NewGenericFeedCursor("test", FeedTypeMedia)
+	cursor.SetClient(NewTwitterHTTPFromDir(dir))
+	var replayed []uint64
+	for result := range NewTwitterSession(cursor).FeedIter() {
+		require.Nil(t, result.Error)
+		replayed = append(replayed, result.Tweet.ID)
+	}
+	assert.Equal(t, captured, replayed)
+}
+
+func TestFixtureName(t *testing.T) {
+	name := func(rawURL string) string {
+		request, err := http.NewRequest("GET", rawURL, nil)
+		require.Nil(t, err)
+		return fixtureName(request)
+	}
+
+	assert.Equal(t, "i_search_timeline", name("https://twitter.com/i/search/timeline"))
+	first := name("https://twitter.com/i/search/timeline?q=cats&f=tweets&max_position=5")
+	assert.True(t, strings.HasPrefix(first, "i_search_timeline@5~"), first)
+	// Order of parameters doesn't matter.
+	assert.Equal(t, first, name("https://twitter.com/i/search/timeline?max_position=5&f=tweets&q=cats"))
+	// Requests differing in any parameter get different fixtures.
+	assert.NotEqual(t, first, name("https://twitter.com/i/search/timeline?q=dogs&f=tweets&max_position=5"))
+	assert.NotEqual(t, first, name("https://twitter.com/i/search/timeline?q=cats&max_position=5"))
+	assert.NotEqual(t, first,
+		name("https://twitter.com/i/search/timeline?q=cats&f=tweets&max_position=5&min_position=3"))
+}
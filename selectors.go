@@ -0,0 +1,215 @@
+package rattler
+
+// Selectors holds CSS selectors used to extract tweets from Twitter's HTML.
+//
+// Twitter changes its markup from time to time, which breaks extraction until
+// the package is updated. Selectors allow to patch a broken selector at
+// runtime in the meantime:
+//
+//	selectors := rattler.DefaultSelectors()
+//	selectors.Text = "div.tweet-text"
+//	client := rattler.NewTwitterHTTP(rattler.WithSelectors(selectors))
+//
+// Selectors are matched within the node of a tweet unless noted otherwise.
+// Names of the attributes values are read from aren't configurable.
+type Selectors struct {
+	// Tweet matches top level nodes of tweets in page's HTML.
+	Tweet string
+	// TweetID matches node with data-tweet-id attribute, which is used when
+	// the tweet node has no data-item-id attribute.
+	TweetID string
+	// Time matches node with data-time attribute.
+	Time string
+	// Text matches node holding tweet's text.
+	Text string
+	// Image matches gallery image nodes with data-image-url attribute.
+	// ImageElement matches image element within such node, which carries alt
+	// text and dimensions missing from the node itself.
+	Image        string
+	ImageElement string
+	// Card matches node with data-card-url attribute. CardImage matches node
+	// with src attribute of card's preview image within it.
+	Card      string
+	CardImage string
+	// Poll matches container of a poll, which is checked before cards.
+	Poll string
+	// PollChoice matches choices within the poll container. PollChoiceLabel
+	// and PollChoicePercent match label and share of votes within a choice.
+	PollChoice        string
+	PollChoiceLabel   string
+	PollChoicePercent string
+	// PollEndTime matches node with data-end-time attribute and PollFinished
+	// matches marker of a poll that's closed. Both may match the poll
+	// container itself.
+	PollEndTime  string
+	PollFinished string
+	// Quote matches link to a quoted tweet.
+	Quote string
+	// QuotedTweet matches container of a quoted tweet, whose markers don't
+	// belong to the quoting tweet.
+	QuotedTweet string
+	// QuoteText and QuoteName match text and author's display name within
+	// the quoted tweet container.
+	QuoteText string
+	QuoteName string
+	// Video matches embedded video player.
+	Video string
+	// GIF matches container of an animated GIF player. Video players within
+	// it aren't treated as videos. GIFVideo matches node with src attribute
+	// of GIF's MP4 file within the container.
+	GIF      string
+	GIFVideo string
+	// Conversation matches node with data-conversation-id attribute.
+	Conversation string
+	// ReplyingTo matches links to users the tweet replies to.
+	ReplyingTo string
+	// Pinned matches marker of a pinned tweet. Tweet node itself is matched
+	// too.
+	Pinned string
+	// Author matches node with data-screen-name attribute.
+	Author string
+	// Retweet matches node with data-retweet-id attribute.
+	Retweet string
+	// VerifiedBadge matches verification badge of the author.
+	VerifiedBadge string
+	// Sensitive matches sensitive content markers. Tweet node itself is
+	// matched too.
+	Sensitive string
+	// Hashtag, Mention and Link match links within tweet's text.
+	Hashtag string
+	Mention string
+	Link    string
+	// Source matches node naming the client the tweet was posted from.
+	// SourceLink matches link to client's website within it.
+	Source     string
+	SourceLink string
+	// Location matches place annotation. LocationLink matches link to the
+	// place within it, unless the annotation is a link itself.
+	Location     string
+	LocationLink string
+	// PermalinkTweet matches container of the tweet on its status page.
+	PermalinkTweet string
+}
+
+// DefaultSelectors returns selectors matching Twitter's current markup.
+func DefaultSelectors() Selectors {
+	return Selectors{
+		Tweet:             "li[data-item-type=tweet]",
+		TweetID:           "div[data-tweet-id]",
+		Time:              "*[data-time]",
+		Text:              "p.tweet-text",
+		Image:             "div[data-image-url]",
+		ImageElement:      "img",
+		Card:              "*[data-card-url]",
+		CardImage:         "img[src]",
+		Poll:              "*[data-card2-name^=poll], div.PollXChoice",
+		PollChoice:        ".PollXChoice-choice",
+		PollChoiceLabel:   ".PollXChoice-choice--text",
+		PollChoicePercent: ".PollXChoice-progress",
+		PollEndTime:       "*[data-end-time]",
+		PollFinished:      ".is-final",
+		Quote:             "div.QuoteTweet-link",
+		QuotedTweet:       ".QuoteTweet",
+		QuoteText:         ".QuoteTweet-text",
+		QuoteName:         ".QuoteTweet-fullname",
+		Video:             "div.PlayableMedia-player",
+		GIF:               "div.PlayableMedia--gif",
+		GIFVideo:          "video[src], video source[src]",
+		Conversation:      "div[data-conversation-id]",
+		ReplyingTo:        "div.ReplyingToContextBelowAuthor a[href]",
+		Pinned:            "div.user-pinned, .js-pinned",
+		Author:            "div[data-screen-name]",
+		Retweet:           "div[data-retweet-id]",
+		// Badge markup has changed over time, so several variants are tried.
+		VerifiedBadge: ".stream-item-header .Icon--verified, " +
+			".stream-item-header .UserBadges *[aria-label=\"Verified account\"], " +
+			".stream-item-header *[data-testid=\"icon-verified\"]",
+		Sensitive:      "*[data-possibly-sensitive=\"true\"], .is-sensitive",
+		Hashtag:        "a.twitter-hashtag",
+		Mention:        "a.twitter-atreply[href]",
+		Link:           "a.twitter-timeline-link[data-expanded-url]",
+		Source:         "*[data-source], .tweet-source",
+		SourceLink:     "a",
+		Location:       "a.tweet-geo-text, span.Tweet-geo, *[data-place-id]",
+		LocationLink:   "a[href]",
+		PermalinkTweet: "div.permalink-tweet-container",
+	}
+}
+
+var defaultSelectors = DefaultSelectors()
+
+// withDefaults returns a copy of selectors with empty selectors replaced by
+// the default ones.
+func (s Selectors) withDefaults() Selectors {
+	or := func(selector, fallback string) string {
+		if len(selector) == 0 {
+			return fallback
+		}
+		return selector
+	}
+	d := defaultSelectors
+	return Selectors{
+		Tweet:             or(s.Tweet, d.Tweet),
+		TweetID:           or(s.TweetID, d.TweetID),
+		Time:              or(s.Time, d.Time),
+		Text:              or(s.Text, d.Text),
+		Image:             or(s.Image, d.Image),
+		ImageElement:      or(s.ImageElement, d.ImageElement),
+		Card:              or(s.Card, d.Card),
+		CardImage:         or(s.CardImage, d.CardImage),
+		Poll:              or(s.Poll, d.Poll),
+		PollChoice:        or(s.PollChoice, d.PollChoice),
+		PollChoiceLabel:   or(s.PollChoiceLabel, d.PollChoiceLabel),
+		PollChoicePercent: or(s.PollChoicePercent, d.PollChoicePercent),
+		PollEndTime:       or(s.PollEndTime, d.PollEndTime),
+		PollFinished:      or(s.PollFinished, d.PollFinished),
+		Quote:             or(s.Quote, d.Quote),
+		QuotedTweet:       or(s.QuotedTweet, d.QuotedTweet),
+		QuoteText:         or(s.QuoteText, d.QuoteText),
+		QuoteName:         or(s.QuoteName, d.QuoteName),
+		Video:             or(s.Video, d.Video),
+		GIF:               or(s.GIF, d.GIF),
+		GIFVideo:          or(s.GIFVideo, d.GIFVideo),
+		Conversation:      or(s.Conversation, d.Conversation),
+		ReplyingTo:        or(s.ReplyingTo, d.ReplyingTo),
+		Pinned:            or(s.Pinned, d.Pinned),
+		Author:            or(s.Author, d.Author),
+		Retweet:           or(s.Retweet, d.Retweet),
+		VerifiedBadge:     or(s.VerifiedBadge, d.VerifiedBadge),
+		Sensitive:         or(s.Sensitive, d.Sensitive),
+		Hashtag:           or(s.Hashtag, d.Hashtag),
+		Mention:           or(s.Mention, d.Mention),
+		Link:              or(s.Link, d.Link),
+		Source:            or(s.Source, d.Source),
+		SourceLink:        or(s.SourceLink, d.SourceLink),
+		Location:          or(s.Location, d.Location),
+		LocationLink:      or(s.LocationLink, d.LocationLink),
+		PermalinkTweet:    or(s.PermalinkTweet, d.PermalinkTweet),
+	}
+}
+
+// WithSelectors makes the client extract tweets using given selectors. Empty
+// selectors fall back to the default ones.
+func WithSelectors(selectors Selectors) HTTPOption {
+	return func(t *TwitterHTTP) {
+		merged := selectors.withDefaults()
+		t.selectors = &merged
+	}
+}
+
+// SetSelectors makes the page extract tweets using given selectors. Empty
+// selectors fall back to the default ones.
+//
+// Must be called before tweets are extracted.
+func (t *FeedPage) SetSelectors(selectors Selectors) {
+	merged := selectors.withDefaults()
+	t.selectors = &merged
+}
+
+// css returns selectors of the page, falling back to the default ones.
+func (t *FeedPage) css() *Selectors {
+	if t.selectors == nil {
+		return &defaultSelectors
+	}
+	return t.selectors
+}
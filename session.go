@@ -1,48 +1,605 @@
 package rattler
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"compress/zlib"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/andybalholm/brotli"
+	log "github.com/sirupsen/logrus"
 )
 
+// DefaultDedupeCapacity is the default number of most recently seen tweet IDs
+// remembered by a session for the purpose of duplicate suppression.
+const DefaultDedupeCapacity = 50000
+
 // TwitterSession represents a single scraping session.
 type TwitterSession struct {
 	cursor     FeedCursor
-	seenTweets map[uint64]struct{}
+	seenTweets SeenSet
+	logger     log.FieldLogger
+	pageDelay  time.Duration
+	pageJitter time.Duration
+
+	// Closing the channel stops goroutines of running iterators, which are
+	// tracked by workers. The mutex guards against starting new ones during
+	// Close().
+	closed      chan struct{}
+	closedMutex sync.Mutex
+	workers     sync.WaitGroup
+
+	// Reason why the last iteration ended, updated atomically.
+	termination int32
+
+	// Counters reported by Stats(), updated atomically.
+	pagesFetched      int64
+	tweetsEmitted     int64
+	duplicatesDropped int64
+}
+
+// Stats holds counters describing the progress of a session.
+//
+// BytesRead is the number of response body bytes read by the TwitterHTTP used
+// by session's cursor. When the client is shared, it includes traffic of every
+// session and cursor using it. PacingDelay is the client's current delay
+// between requests (see WithAdaptivePacing()).
+type Stats struct {
+	PagesFetched      int64
+	TweetsEmitted     int64
+	DuplicatesDropped int64
+	BytesRead         int64
+	PacingDelay       time.Duration
+}
+
+// SeenSet records IDs of tweets emitted by a session, so that duplicates can
+// be suppressed. A set shared by sessions that run concurrently has to be safe
+// for concurrent use.
+type SeenSet interface {
+	Has(id uint64) bool
+	Add(id uint64)
+}
+
+// TerminationReason describes why FeedIter() stopped.
+type TerminationReason int32
+
+const (
+	// TerminationNone means that the iteration hasn't ended yet or that
+	// none has been started.
+	TerminationNone TerminationReason = iota
+	// TerminationCompleted means that the end of the feed has been reached.
+	TerminationCompleted
+	// TerminationLimitReached means that the iteration was stopped by
+	// SinglePage(), MaxPages(), MaxTweets() or MaxDuration().
+	TerminationLimitReached
+	// TerminationCancelled means that the session was closed or the context
+	// passed to Context() was cancelled before the iteration ended.
+	TerminationCancelled
+	// TerminationError means that the last result carried an error.
+	TerminationError
+)
+
+var terminationNames = map[TerminationReason]string{
+	TerminationNone:         "none",
+	TerminationCompleted:    "completed",
+	TerminationLimitReached: "limit-reached",
+	TerminationCancelled:    "cancelled",
+	TerminationError:        "error",
 }
 
+func (r TerminationReason) String() string {
+	if name, exists := terminationNames[r]; exists {
+		return name
+	}
+	return fmt.Sprintf("TerminationReason(%d)", int32(r))
+}
+
+// SessionOption configures a TwitterSession created by NewTwitterSession().
+type SessionOption func(*TwitterSession)
+
+// DefaultUserAgent is the User-Agent header sent with requests, unless
+// overridden with WithUserAgent().
+const DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:67.0) " +
+	"Gecko/20100101 Firefox/67.0"
+
+// DefaultTimeout is the time limit for a single request, unless overridden
+// with WithTimeout().
+const DefaultTimeout = 30 * time.Second
+
 // TwitterHTTP is a session parameters that can be shared across multiple
 // TwitterSession`s.
 type TwitterHTTP struct {
-	httpClient *http.Client
+	httpClient  *http.Client
+	userAgent   string
+	cookieJar   http.CookieJar
+	bearerToken string
+	headers     map[string]string
+	language    string
+	logger      log.FieldLogger
+	baseURL     url.URL
+
+	jsonTee      io.Writer
+	jsonTeeMutex sync.Mutex
+
+	bytesRead     int64
+	retryPolicy   RetryPolicy
+	responseCache ResponseCache
+	pacer         *pacer
+	selectors     *Selectors
+
+	// Wrappers installed by options such as WithCapture(). They're applied
+	// again whenever the underlying transport is replaced, so they don't
+	// depend on the order of options.
+	transportWrappers []func(http.RoundTripper) http.RoundTripper
+}
+
+// RetryPolicy controls retrying of requests whose compressed response body
+// turned out to be corrupt, which is usually caused by a truncated response
+// and goes away when the request is repeated.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a request is repeated before the
+	// error is returned. Zero disables retrying.
+	MaxRetries int
+	// Backoff is the delay before the first retry. It's doubled for every
+	// subsequent retry.
+	Backoff time.Duration
 }
 
+// DefaultRetryPolicy is the retry policy used unless overridden with
+// WithRetryPolicy().
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 2, Backoff: time.Second}
+
+// ConnectionPool controls how connections to Twitter are kept alive and
+// reused. Zero values mean no limit, see http.Transport for details.
+type ConnectionPool struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+// DefaultConnectionPool is the connection pool used unless overridden with
+// WithConnectionPool(). Unlike Go's defaults, which keep only two idle
+// connections per host, it's tuned for sending many requests to a few hosts.
+//
+// Changes affect clients created afterwards, which no longer share
+// connections with the ones created before.
+var DefaultConnectionPool = ConnectionPool{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// defaultTransport is shared by clients that don't set their own transport or
+// connection pool, so that they reuse connections of each other. It's built
+// from DefaultConnectionPool on demand, see sharedTransport().
+var (
+	defaultTransport      *http.Transport
+	defaultTransportPool  ConnectionPool
+	defaultTransportMutex sync.Mutex
+)
+
+// sharedTransport returns defaultTransport, which is rebuilt if
+// DefaultConnectionPool has changed since the last call.
+func sharedTransport() *http.Transport {
+	defaultTransportMutex.Lock()
+	defer defaultTransportMutex.Unlock()
+	if defaultTransport == nil || defaultTransportPool != DefaultConnectionPool {
+		defaultTransport = newPooledTransport(DefaultConnectionPool)
+		defaultTransportPool = DefaultConnectionPool
+	}
+	return defaultTransport
+}
+
+// newPooledTransport creates a transport with Go's default settings apart from
+// the connection pool.
+func newPooledTransport(pool ConnectionPool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = pool.MaxIdleConns
+	transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = pool.MaxConnsPerHost
+	transport.IdleConnTimeout = pool.IdleConnTimeout
+	return transport
+}
+
+// HTTPOption configures TwitterHTTP created by NewTwitterHTTP().
+type HTTPOption func(*TwitterHTTP)
+
 // NewTwitterHTTP creates new session parameters.
-func NewTwitterHTTP() *TwitterHTTP {
-	return &TwitterHTTP{
+func NewTwitterHTTP(options ...HTTPOption) *TwitterHTTP {
+	client := &TwitterHTTP{
 		httpClient: &http.Client{
-			Timeout:       30 * time.Second,
+			Transport:     sharedTransport(),
+			Timeout:       DefaultTimeout,
 			CheckRedirect: handleRedirect,
 		},
+		userAgent: DefaultUserAgent,
+		logger:    log.StandardLogger(),
+		baseURL:   url.URL{Scheme: "https", Host: "twitter.com"},
+
+		retryPolicy: DefaultRetryPolicy,
+	}
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// setTransport replaces the underlying transport of the client, keeping the
+// wrappers installed by options.
+func (t *TwitterHTTP) setTransport(transport http.RoundTripper) {
+	for _, wrap := range t.transportWrappers {
+		transport = wrap(transport)
+	}
+	t.httpClient.Transport = transport
+}
+
+// wrapTransport installs a wrapper around the current and any future
+// underlying transport of the client.
+func (t *TwitterHTTP) wrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	t.transportWrappers = append(t.transportWrappers, wrap)
+	t.httpClient.Transport = wrap(t.httpClient.Transport)
+}
+
+// WithUserAgent sets User-Agent header sent with every request.
+func WithUserAgent(userAgent string) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.userAgent = userAgent
+	}
+}
+
+// WithHeaders sets additional headers sent with every request. They are
+// applied on top of the headers the client sets by itself, so a header
+// present in the map overrides the default value and the remaining defaults
+// are kept. Header names are case-insensitive. The option may be passed
+// several times, later values win.
+func WithHeaders(headers map[string]string) HTTPOption {
+	return func(t *TwitterHTTP) {
+		if t.headers == nil {
+			t.headers = make(map[string]string, len(headers))
+		}
+		for name, value := range headers {
+			t.headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+}
+
+// WithLanguage makes the client ask Twitter for content in the given language,
+// a BCP 47 code such as "ja" or "pt-BR". The language is sent in
+// Accept-Language header of every request. Search requests also carry the
+// language without region as lang parameter, which takes ISO 639-1 codes.
+//
+// Twitter localizes more than UI strings: the language can change which
+// tweets search and some timelines return, e.g. Top search results favour
+// tweets in the requested language. Accept-Language set by WithHeaders()
+// takes precedence.
+func WithLanguage(language string) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.language = language
+	}
+}
+
+// acceptLanguage returns the value of Accept-Language header for the client's
+// language, which also accepts the language without region at lower priority.
+func (t *TwitterHTTP) acceptLanguage() string {
+	if len(t.language) == 0 {
+		return "en-US,en;q=0.9"
+	}
+	if primary := t.primaryLanguage(); primary != t.language {
+		return t.language + "," + primary + ";q=0.9"
+	}
+	return t.language
+}
+
+// primaryLanguage returns the client's language without region or script,
+// e.g. "pt" for "pt-BR".
+func (t *TwitterHTTP) primaryLanguage() string {
+	if i := strings.IndexAny(t.language, "-_"); i > 0 {
+		return t.language[:i]
+	}
+	return t.language
+}
+
+// WithTimeout sets the time limit for a single request, which includes
+// connecting, following redirects and reading the response body. Zero means no
+// limit.
+//
+// Cursors use the timeout of the client given to their SetClient() method.
+//
+// The timeout is per request. A request bound to a context, e.g. by
+// DownloadContext(), fails at the earlier of the timeout and the context's
+// deadline or cancellation. The context passed to FeedIter() by Context()
+// doesn't shorten a page request in progress: it stops the iteration between
+// requests, so a page request still ends at the timeout.
+func WithTimeout(timeout time.Duration) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.httpClient.Timeout = timeout
+	}
+}
+
+// WithTransport makes the client send requests through the given transport,
+// e.g. one that signs or caches requests. WithCapture() wraps the transport
+// regardless of the order of options.
+func WithTransport(transport http.RoundTripper) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.setTransport(transport)
+	}
+}
+
+// WithConnectionPool makes the client keep connections according to the given
+// pool instead of DefaultConnectionPool. The client gets a transport of its
+// own, so the option replaces the transport set by WithTransport(). Like
+// WithTransport(), it's wrapped by WithCapture() regardless of the order of
+// options.
+func WithConnectionPool(pool ConnectionPool) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.setTransport(newPooledTransport(pool))
+	}
+}
+
+// WithLogger makes requests and pages retrieved through the client write their
+// messages into the given logger instead of the global logrus logger.
+func WithLogger(logger log.FieldLogger) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.logger = logger
+	}
+}
+
+// WithRetryPolicy sets how requests with corrupt compressed responses are
+// retried.
+func WithRetryPolicy(policy RetryPolicy) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.retryPolicy = policy
+	}
+}
+
+// WithBaseURL makes the client send requests to an alternative host, e.g. a
+// mirror serving compatible markup, instead of https://twitter.com. Path of
+// baseURL, if any, is prepended to request paths.
+//
+// Media downloads are not affected.
+func WithBaseURL(baseURL *url.URL) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.baseURL = *baseURL
 	}
 }
 
 // NewTwitterSession creates new TwitterSession based on given cursor.
-func NewTwitterSession(cursor FeedCursor) *TwitterSession {
+func NewTwitterSession(cursor FeedCursor, options ...SessionOption) *TwitterSession {
 	session := &TwitterSession{
 		cursor:     cursor,
-		seenTweets: make(map[uint64]struct{}),
+		seenTweets: newTweetIDLRU(DefaultDedupeCapacity),
+		logger:     log.StandardLogger(),
+		closed:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(session)
 	}
 	return session
 }
 
+// WithDedupeCapacity sets how many most recently seen tweet IDs the session
+// remembers for duplicate suppression. Older IDs are forgotten once the limit
+// is reached.
+//
+// Since feeds are roughly chronological, duplicates can only be expected
+// among recently seen tweets, so the limit doesn't affect correctness as long
+// as it's comfortably larger than a page. Non-positive capacity means
+// DefaultDedupeCapacity.
+func WithDedupeCapacity(capacity int) SessionOption {
+	if capacity <= 0 {
+		capacity = DefaultDedupeCapacity
+	}
+	return func(t *TwitterSession) {
+		t.seenTweets = newTweetIDLRU(capacity)
+	}
+}
+
+// WithSeenTweets makes the session use the given set for duplicate suppression
+// instead of its own in-memory set, e.g. one backed by a persistent store, so
+// that a resumed run or several sessions don't emit the same tweets twice.
+//
+// The set is owned by the caller and isn't cleared by Close().
+func WithSeenTweets(set SeenSet) SessionOption {
+	return func(t *TwitterSession) {
+		t.seenTweets = set
+	}
+}
+
+// WithPageDelay makes FeedIter() pause between page retrievals for the given
+// delay plus a random duration of up to jitter. The first page is retrieved
+// immediately.
+//
+// Zero delay and jitter, which is the default, disable the pause.
+func WithPageDelay(delay, jitter time.Duration) SessionOption {
+	return func(t *TwitterSession) {
+		t.pageDelay = delay
+		t.pageJitter = jitter
+	}
+}
+
+// nextPageDelay returns how long to wait before retrieving the next page.
+func (t *TwitterSession) nextPageDelay() time.Duration {
+	return jitteredDelay(t.pageDelay, t.pageJitter)
+}
+
+// jitteredDelay returns the delay plus a random duration of up to jitter.
+func jitteredDelay(delay, jitter time.Duration) time.Duration {
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return delay
+}
+
+// feedLogger returns session's logger with fields identifying the feed of
+// session's cursor.
+func (t *TwitterSession) feedLogger() log.FieldLogger {
+	if cursor, ok := t.cursor.(interface{ logFields() log.Fields }); ok {
+		return t.logger.WithFields(cursor.logFields())
+	}
+	return t.logger
+}
+
+// WithSessionLogger makes the session write its messages into the given
+// logger instead of the global logrus logger.
+//
+// Messages produced while retrieving and parsing pages are written into the
+// logger of cursor's TwitterHTTP, see WithLogger().
+func WithSessionLogger(logger log.FieldLogger) SessionOption {
+	return func(t *TwitterSession) {
+		t.logger = logger
+	}
+}
+
+// Stats returns a snapshot of session's counters. It's safe to call while
+// FeedIter() is running.
+func (t *TwitterSession) Stats() Stats {
+	stats := Stats{
+		PagesFetched:      atomic.LoadInt64(&t.pagesFetched),
+		TweetsEmitted:     atomic.LoadInt64(&t.tweetsEmitted),
+		DuplicatesDropped: atomic.LoadInt64(&t.duplicatesDropped),
+	}
+	if cursor, ok := t.cursor.(interface{ twitterHTTP() *TwitterHTTP }); ok {
+		if client := cursor.twitterHTTP(); client != nil {
+			stats.BytesRead = client.BytesRead()
+			stats.PacingDelay = client.PacingDelay()
+		}
+	}
+	return stats
+}
+
+// TerminationReason returns why the last iteration started by FeedIter()
+// ended. It's set by the time the iterator's channel is closed.
+//
+// A consumer that stops receiving is only noticed once it cancels the context
+// passed to Context() or closes the session, at which point the reason becomes
+// TerminationCancelled. Until then the reason stays TerminationNone.
+func (t *TwitterSession) TerminationReason() TerminationReason {
+	return TerminationReason(atomic.LoadInt32(&t.termination))
+}
+
+func (t *TwitterSession) setTermination(reason TerminationReason) {
+	atomic.StoreInt32(&t.termination, int32(reason))
+}
+
+// Close stops iterators of the session, closes idle connections of the
+// cursor's client and forgets tweets seen by the session, unless they are
+// recorded in a set given to WithSeenTweets(). Channels of stopped
+// iterators are closed, possibly before all of their results were read.
+//
+// Close waits for iterator goroutines to exit, which includes a page
+// retrieval that's in progress. For iterators that have been drained, Close is
+// a no-op, and so is calling it more than once. A closed session can't be
+// used for scraping anymore.
+func (t *TwitterSession) Close() error {
+	t.closedMutex.Lock()
+	select {
+	case <-t.closed:
+	default:
+		close(t.closed)
+	}
+	t.closedMutex.Unlock()
+	t.workers.Wait()
+
+	if lru, ok := t.seenTweets.(*tweetIDLRU); ok {
+		t.seenTweets = newTweetIDLRU(lru.capacity)
+	}
+	if cursor, ok := t.cursor.(interface{ twitterHTTP() *TwitterHTTP }); ok {
+		if client := cursor.twitterHTTP(); client != nil {
+			client.httpClient.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// startWorkers registers n goroutines of an iterator that's about to start.
+// Returns false if the session is closed, in which case the iterator
+// mustn't be started.
+func (t *TwitterSession) startWorkers(n int) bool {
+	t.closedMutex.Lock()
+	defer t.closedMutex.Unlock()
+	select {
+	case <-t.closed:
+		return false
+	default:
+	}
+	t.workers.Add(n)
+	return true
+}
+
+// Position returns the position of session's cursor, i.e. the position of the
+// next page that will be retrieved.
+//
+// The value can be persisted and passed to the cursor constructor's resumeAt
+// argument later to resume scraping. It should not be called while FeedIter()
+// is fetching pages, since the iterator advances the cursor in background.
+func (t *TwitterSession) Position() string {
+	return t.cursor.Position()
+}
+
+// WithCookies makes every request carry cookies from the given jar, e.g. to
+// scrape with a logged in session. Cookies set by the server are stored back
+// into the jar.
+//
+// If the jar holds Twitter's "ct0" cookie, its value is also sent in
+// X-Csrf-Token header as required by authenticated endpoints.
+func WithCookies(jar http.CookieJar) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.cookieJar = jar
+		t.httpClient.Jar = jar
+	}
+}
+
+// WithBearer makes every request carry the given token in Authorization
+// header.
+func WithBearer(token string) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.bearerToken = token
+	}
+}
+
+// WithJSONTee copies the raw body of every JSON response into w as it's being
+// decoded, e.g. to archive retrieved pages for replay or debugging. Each body
+// is followed by a newline.
+//
+// Requests made through the same TwitterHTTP are serialized while writing
+// into w, so the bodies never interleave.
+func WithJSONTee(w io.Writer) HTTPOption {
+	return func(t *TwitterHTTP) {
+		t.jsonTee = w
+	}
+}
+
+// BytesRead returns the number of response body bytes read through the client.
+func (t *TwitterHTTP) BytesRead() int64 {
+	return atomic.LoadInt64(&t.bytesRead)
+}
+
+// endpoint returns URL of the given path and query on client's host.
+func (t *TwitterHTTP) endpoint(path string, params url.Values) url.URL {
+	aURL := t.baseURL
+	aURL.Path = strings.TrimSuffix(aURL.Path, "/") + path
+	aURL.RawPath = ""
+	aURL.RawQuery = ""
+	if params != nil {
+		aURL.RawQuery = params.Encode()
+	}
+	return aURL
+}
+
 func (t *TwitterHTTP) newRequest(aURL url.URL) (*http.Request, error) {
 	return t.newRequestS(aURL.String())
 }
@@ -50,63 +607,296 @@ func (t *TwitterHTTP) newRequest(aURL url.URL) (*http.Request, error) {
 func (t *TwitterHTTP) newRequestS(aURL string) (*http.Request, error) {
 	request, err := http.NewRequest("GET", aURL, nil)
 	if err != nil {
-		return nil, &URLError{"Unable to create request object", aURL, err}
+		return nil, &URLError{msg: "Unable to create request object", url: aURL, cause: err}
 	}
-	configureRequest(request)
+	t.configureRequest(request)
 	return request, nil
 }
 
 func (t *TwitterHTTP) httpRequest(request *http.Request) (io.ReadCloser, error) {
+	response, err := t.do(request)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// do executes the request and returns the response, whose body is already
+// decompressed. Responses with status other than 200 are turned into errors.
+//
+// If the client has a response cache, cached responses are revalidated and
+// served when the server reports them as not modified.
+func (t *TwitterHTTP) do(request *http.Request) (*http.Response, error) {
+	// Transports may rewrite request's URL, so the key is taken upfront.
+	cacheKey := request.URL.String()
+	cached, isCached := t.addConditionalHeaders(request)
+	if t.pacer != nil {
+		if err := t.pacer.wait(request.Context()); err != nil {
+			return nil, &URLError{msg: "Failed to execute HTTP request", url: request.URL.String(), cause: err}
+		}
+	}
 	response, err := t.httpClient.Do(request)
 	if err != nil {
-		return nil, &URLError{"Failed to execute HTTP request", request.URL.String(), err}
+		return nil, &URLError{msg: "Failed to execute HTTP request", url: request.URL.String(), cause: err}
+	}
+	if t.pacer != nil {
+		t.pacer.update(response)
+	}
+
+	if isCached && response.StatusCode == http.StatusNotModified {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		t.logger.WithField("url", request.URL.String()).Debug("Serving response from cache")
+		response.StatusCode = http.StatusOK
+		response.Header.Del("Content-Encoding")
+		response.Body = ioutil.NopCloser(bytes.NewReader(cached.Body))
+		return response, nil
 	}
 
 	if response.StatusCode != http.StatusOK {
 		io.Copy(ioutil.Discard, response.Body)
 		response.Body.Close()
-		statusText := http.StatusText(response.StatusCode)
-		return nil, &URLError{"HTTP error", request.URL.String(), fmt.Errorf(statusText)}
-	}
-
-	// Twitter does not respect Accept-Encoding (which is set to 'gzip' by Go) and
-	// returns response compressed with zlib.
-	//
-	// https://github.com/golang/go/issues/18779
-	if strings.ToLower(response.Header.Get("Content-Encoding")) == "deflate" {
-		reader, zlibErr := zlib.NewReader(response.Body)
-		if zlibErr != nil {
-			return nil, &URLError{"Corrupt ZLIB stream", request.URL.String(), zlibErr}
+		return nil, &URLError{
+			msg:        "HTTP error",
+			url:        request.URL.String(),
+			cause:      errors.New(http.StatusText(response.StatusCode)),
+			statusCode: response.StatusCode,
+		}
+	}
+
+	response.Body = &countingBody{response.Body, &t.bytesRead}
+	reader, err := decodeBody(response)
+	if err != nil {
+		response.Body.Close()
+		return nil, &URLError{msg: "Unable to decode response body", url: request.URL.String(), cause: err}
+	}
+	if reader != nil {
+		response.Body = &wrappedBody{reader, response.Body}
+	}
+
+	etag := response.Header.Get("ETag")
+	lastModified := response.Header.Get("Last-Modified")
+	if t.responseCache != nil && request.Method == http.MethodGet &&
+		(len(etag) > 0 || len(lastModified) > 0) {
+		data, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, &URLError{msg: "Failed to read response body", url: request.URL.String(), cause: err}
+		}
+		t.responseCache.Put(cacheKey, CachedResponse{
+			ETag:         etag,
+			LastModified: lastModified,
+			Body:         data,
+		})
+		response.Body = ioutil.NopCloser(bytes.NewReader(data))
+	}
+
+	return response, nil
+}
+
+// decodeBody returns a reader that decompresses response body according to
+// its Content-Encoding or nil if the body isn't compressed.
+//
+// Twitter does not respect Accept-Encoding (which is set to 'gzip' by Go) and
+// may return response compressed with zlib, gzip or brotli even if Go's
+// transport doesn't expect it.
+//
+// https://github.com/golang/go/issues/18779
+func decodeBody(response *http.Response) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return nil, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, &corruptStreamError{fmt.Errorf("Corrupt GZIP stream: %w", err)}
 		}
 		return reader, nil
+	case "deflate":
+		// Deflate is supposed to be wrapped into zlib format, but some servers
+		// send raw deflate stream instead.
+		buffered := bufio.NewReader(response.Body)
+		header, err := buffered.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint(header[0])<<8|uint(header[1]))%31 == 0 {
+			reader, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, &corruptStreamError{fmt.Errorf("Corrupt ZLIB stream: %w", err)}
+			}
+			return reader, nil
+		}
+		return flate.NewReader(buffered), nil
+	case "br":
+		return ioutil.NopCloser(brotli.NewReader(response.Body)), nil
+	default:
+		return nil, fmt.Errorf("Unsupported Content-Encoding '%s'", encoding)
 	}
+}
 
-	return response.Body, nil
+// wrappedBody reads from a decoder stacked on top of response body and closes
+// both when done.
+type wrappedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+// Read reads decompressed data. Decompression failures are reported as
+// *corruptStreamError.
+func (t *wrappedBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = &corruptStreamError{err}
+	}
+	return n, err
+}
+
+func (t *wrappedBody) Close() error {
+	err := t.ReadCloser.Close()
+	if bodyErr := t.body.Close(); err == nil {
+		err = bodyErr
+	}
+	return err
+}
+
+// countingBody adds the number of bytes read from response body to a counter.
+type countingBody struct {
+	io.ReadCloser
+	counter *int64
+}
+
+func (t *countingBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	atomic.AddInt64(t.counter, int64(n))
+	return n, err
+}
+
+// corruptStreamError occurs when compressed response body can't be
+// decompressed.
+type corruptStreamError struct {
+	err error
+}
+
+func (e *corruptStreamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *corruptStreamError) Unwrap() error {
+	return e.err
 }
 
+// jsonRequest executes the request and decodes its JSON response. Requests
+// whose response turns out to be corrupt are retried according to client's
+// retry policy.
 func (t *TwitterHTTP) jsonRequest(request *http.Request) (interface{}, error) {
-	bodyReader, err := t.httpRequest(request)
+	structuredJSON, _, err := t.jsonRequestMeta(request)
+	return structuredJSON, err
+}
+
+// responseMeta holds details of a response that are kept along with the
+// decoded JSON.
+type responseMeta struct {
+	// size is the size of decoded response body.
+	size int
+	// header holds response headers listed in keptHeaders.
+	header http.Header
+}
+
+// keptHeaders are the response headers kept in responseMeta.
+var keptHeaders = []string{
+	"X-Rate-Limit-Limit",
+	"X-Rate-Limit-Remaining",
+	"X-Rate-Limit-Reset",
+}
+
+// jsonRequestMeta is like jsonRequest, but also returns details of the
+// response.
+func (t *TwitterHTTP) jsonRequestMeta(request *http.Request) (interface{}, responseMeta, error) {
+	backoff := t.retryPolicy.Backoff
+	for retry := 0; ; retry++ {
+		structuredJSON, meta, err := t.jsonRequestOnce(request)
+		var streamErr *corruptStreamError
+		if err == nil || retry >= t.retryPolicy.MaxRetries || !errors.As(err, &streamErr) {
+			return structuredJSON, meta, err
+		}
+
+		t.logger.WithField("error", err.Error()).Debug("Retrying request with corrupt response")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, meta, &URLError{
+				msg:   "Failed to execute HTTP request",
+				url:   request.URL.String(),
+				cause: request.Context().Err(),
+			}
+		}
+		backoff *= 2
+	}
+}
+
+func (t *TwitterHTTP) jsonRequestOnce(request *http.Request) (interface{}, responseMeta, error) {
+	var meta responseMeta
+	response, err := t.do(request)
 	if err != nil {
-		return nil, err
+		return nil, meta, err
 	}
+	bodyReader := response.Body
 	defer bodyReader.Close()
 
+	for _, name := range keptHeaders {
+		if value := response.Header.Get(name); len(value) > 0 {
+			if meta.header == nil {
+				meta.header = make(http.Header)
+			}
+			meta.header.Set(name, value)
+		}
+	}
+
+	var size int64
+	var reader io.Reader = &countingBody{bodyReader, &size}
+	if t.jsonTee != nil {
+		t.jsonTeeMutex.Lock()
+		defer t.jsonTeeMutex.Unlock()
+		reader = io.TeeReader(reader, t.jsonTee)
+	}
+
 	var structuredJSON interface{}
-	decoder := json.NewDecoder(bodyReader)
+	decoder := json.NewDecoder(reader)
 	err = decoder.Decode(&structuredJSON)
+
+	// Drain the reader to allow reuse of current connection and to let the tee
+	// receive the complete body.
+	io.Copy(ioutil.Discard, reader)
+	if t.jsonTee != nil {
+		io.WriteString(t.jsonTee, "\n")
+	}
+
+	meta.size = int(size)
 	if err != nil {
-		// Drain the reader to allow reuse of current connection.
-		io.Copy(ioutil.Discard, bodyReader)
-		return nil, &URLError{"Failed to decode JSON response", request.URL.String(), err}
+		return nil, meta, &URLError{msg: "Failed to decode JSON response", url: request.URL.String(), cause: err}
 	}
-	return structuredJSON, nil
+	return structuredJSON, meta, nil
 }
 
-func configureRequest(request *http.Request) {
+func (t *TwitterHTTP) configureRequest(request *http.Request) {
 	request.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml,*/*;q=0.8")
-	request.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	request.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:67.0) "+
-		"Gecko/20100101 Firefox/67.0")
+	request.Header.Set("Accept-Language", t.acceptLanguage())
+	request.Header.Set("User-Agent", t.userAgent)
+
+	if len(t.bearerToken) > 0 {
+		request.Header.Set("Authorization", "Bearer "+t.bearerToken)
+	}
+	if t.cookieJar != nil {
+		for _, cookie := range t.cookieJar.Cookies(request.URL) {
+			if cookie.Name == "ct0" {
+				request.Header.Set("X-Csrf-Token", cookie.Value)
+				break
+			}
+		}
+	}
+	for name, value := range t.headers {
+		request.Header.Set(name, value)
+	}
 }
 
 func handleRedirect(req *http.Request, via []*http.Request) error {
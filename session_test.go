@@ -1,8 +1,24 @@
 package rattler
 
 import (
-	"github.com/stretchr/testify/assert"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExtractExtension(t *testing.T) {
@@ -14,3 +30,404 @@ func TestExtractExtension(t *testing.T) {
 	ext = extractFileExtFromURL("https://example.com/test.jpeg?test=1.png")
 	assert.Equal(t, "jpeg", ext)
 }
+
+func TestCursorPositionRoundTrip(t *testing.T) {
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	assert.Equal(t, "", cursor.Position())
+
+	assert.True(t, cursor.Seek("608164787940413441"))
+	assert.Equal(t, "608164787940413441", cursor.Position())
+
+	resumed := NewTwitterSession(NewGenericFeedCursor("test", FeedTypeRegular, cursor.Position()))
+	assert.Equal(t, "608164787940413441", resumed.Position())
+
+	search := NewSearchFeedCursor("test", "386615604008194048")
+	assert.Equal(t, "386615604008194048", search.Position())
+	assert.False(t, search.Seek(""))
+	assert.Equal(t, "386615604008194048", search.Position())
+}
+
+func TestPositionHandoff(t *testing.T) {
+	var requested []string
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("max_position"))
+		fmt.Fprint(w, `{"items_html": "", "min_position": "100", "max_position": "200"}`)
+	})
+	defer server.Close()
+
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	cursor.client.httpClient = client
+	page, err := cursor.RetrievePage()
+	require.Nil(t, err)
+
+	// Page's min_position is sent as max_position of the next request.
+	position, err := page.GetMinPosition()
+	require.Nil(t, err)
+	assert.Equal(t, "100", position)
+	require.True(t, cursor.Seek(position))
+	assert.Equal(t, "100", cursor.Position())
+	_, err = cursor.RetrievePage()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"", "100"}, requested)
+}
+
+func TestFeedFilterNames(t *testing.T) {
+	for _, feedType := range []FeedFilter{
+		FeedTypeRegular, FeedTypeMedia, FeedTypeWithReplies, FeedTypeLikes,
+	} {
+		parsed, err := ParseFeedFilter(feedType.String())
+		require.Nil(t, err)
+		assert.Equal(t, feedType, parsed)
+	}
+
+	parsed, err := ParseFeedFilter("Replies")
+	require.Nil(t, err)
+	assert.Equal(t, FeedTypeWithReplies, parsed)
+	assert.Equal(t, "media", fmt.Sprint(FeedTypeMedia))
+	assert.Equal(t, "FeedFilter(42)", FeedFilter(42).String())
+
+	_, err = ParseFeedFilter("bookmarks")
+	assert.NotNil(t, err)
+}
+
+func TestUserAgent(t *testing.T) {
+	request, err := NewTwitterHTTP().newRequestS("https://example.com")
+	require.Nil(t, err)
+	assert.Equal(t, DefaultUserAgent, request.Header.Get("User-Agent"))
+
+	request, err = NewTwitterHTTP(WithUserAgent("test/1.0")).newRequestS("https://example.com")
+	require.Nil(t, err)
+	assert.Equal(t, "test/1.0", request.Header.Get("User-Agent"))
+}
+
+func TestHeaders(t *testing.T) {
+	client := NewTwitterHTTP(
+		WithHeaders(map[string]string{"Accept-Language": "ja-JP", "X-Guest-Token": "1"}),
+		WithHeaders(map[string]string{"x-guest-token": "2"}))
+	request, err := client.newRequestS("https://example.com")
+	require.Nil(t, err)
+	assert.Equal(t, "ja-JP", request.Header.Get("Accept-Language"))
+	assert.Equal(t, "2", request.Header.Get("X-Guest-Token"))
+	assert.Equal(t, DefaultUserAgent, request.Header.Get("User-Agent"))
+	assert.NotEmpty(t, request.Header.Get("Accept"))
+}
+
+func TestCursorPageSize(t *testing.T) {
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	request, err := cursor.RequestPreview()
+	require.Nil(t, err)
+	assert.NotContains(t, request.URL.Query(), "count")
+
+	for _, c := range []struct{ size, expected int }{{50, 50}, {1000, 200}, {-1, 0}} {
+		cursor.SetPageSize(c.size)
+		request, err = cursor.RequestPreview()
+		require.Nil(t, err)
+		if c.expected > 0 {
+			assert.Equal(t, strconv.Itoa(c.expected), request.URL.Query().Get("count"))
+		} else {
+			assert.NotContains(t, request.URL.Query(), "count")
+		}
+	}
+
+	search := NewSearchFeedCursor("test")
+	search.SetPageSize(1000)
+	request, err = search.RequestPreview()
+	require.Nil(t, err)
+	assert.Equal(t, "100", request.URL.Query().Get("count"))
+}
+
+func TestLanguage(t *testing.T) {
+	for _, c := range []struct{ language, header string }{
+		{"", "en-US,en;q=0.9"},
+		{"ja", "ja"},
+		{"pt-BR", "pt-BR,pt;q=0.9"},
+	} {
+		request, err := NewTwitterHTTP(WithLanguage(c.language)).newRequestS("https://example.com")
+		require.Nil(t, err)
+		assert.Equal(t, c.header, request.Header.Get("Accept-Language"))
+	}
+
+	search := NewSearchFeedCursor("test")
+	request, err := search.RequestPreview()
+	require.Nil(t, err)
+	assert.NotContains(t, request.URL.Query(), "lang")
+
+	search.SetClient(NewTwitterHTTP(WithLanguage("pt-BR")))
+	request, err = search.RequestPreview()
+	require.Nil(t, err)
+	assert.Equal(t, "pt", request.URL.Query().Get("lang"))
+	assert.Equal(t, "pt-BR,pt;q=0.9", request.Header.Get("Accept-Language"))
+}
+
+func TestTimeout(t *testing.T) {
+	assert.Equal(t, DefaultTimeout, NewTwitterHTTP().httpClient.Timeout)
+
+	httpClient, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	defer server.Close()
+
+	client := NewTwitterHTTP(WithTimeout(20 * time.Millisecond))
+	assert.Equal(t, 20*time.Millisecond, client.httpClient.Timeout)
+	client.httpClient.Transport = httpClient.Transport
+
+	request, err := client.newRequestS("https://twitter.com/")
+	require.Nil(t, err)
+	_, err = client.httpRequest(request)
+	assert.NotNil(t, err)
+}
+
+func TestConnectionPool(t *testing.T) {
+	transport := NewTwitterHTTP().httpClient.Transport.(*http.Transport)
+	assert.Equal(t, DefaultConnectionPool.MaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.True(t, transport == NewTwitterHTTP().httpClient.Transport)
+
+	pool := ConnectionPool{MaxIdleConns: 10, MaxIdleConnsPerHost: 5, MaxConnsPerHost: 8, IdleConnTimeout: time.Minute}
+	transport = NewTwitterHTTP(WithConnectionPool(pool)).httpClient.Transport.(*http.Transport)
+	assert.Equal(t, pool, ConnectionPool{
+		MaxIdleConns:        transport.MaxIdleConns,
+		MaxIdleConnsPerHost: transport.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     transport.MaxConnsPerHost,
+		IdleConnTimeout:     transport.IdleConnTimeout,
+	})
+	assert.NotNil(t, transport.Proxy)
+
+	// Changes of the default pool apply to clients created afterwards.
+	defaultPool := DefaultConnectionPool
+	defer func() { DefaultConnectionPool = defaultPool }()
+	DefaultConnectionPool.MaxIdleConnsPerHost = 4
+	transport = NewTwitterHTTP().httpClient.Transport.(*http.Transport)
+	assert.Equal(t, 4, transport.MaxIdleConnsPerHost)
+}
+
+func TestTransport(t *testing.T) {
+	httpClient, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"test":true}`)
+	})
+	defer server.Close()
+
+	client := NewTwitterHTTP(WithTransport(httpClient.Transport))
+	request, err := client.newRequestS("https://twitter.com/")
+	require.Nil(t, err)
+	structuredJSON, err := client.jsonRequest(request)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"test": true}, structuredJSON)
+
+	// Capture wraps the transport regardless of the order of options.
+	for _, options := range [][]HTTPOption{
+		{WithCapture("capture"), WithTransport(httpClient.Transport)},
+		{WithCapture("capture"), WithConnectionPool(DefaultConnectionPool)},
+	} {
+		capture, ok := NewTwitterHTTP(options...).httpClient.Transport.(*captureTransport)
+		require.True(t, ok)
+		assert.NotNil(t, capture.next)
+	}
+}
+
+func TestAuthHeaders(t *testing.T) {
+	jar, err := cookiejar.New(nil)
+	require.Nil(t, err)
+	twitterURL, _ := url.Parse("https://twitter.com/")
+	jar.SetCookies(twitterURL, []*http.Cookie{
+		{Name: "auth_token", Value: "secret"},
+		{Name: "ct0", Value: "csrf"},
+	})
+
+	client := NewTwitterHTTP(WithCookies(jar), WithBearer("token"))
+	request, err := client.newRequestS("https://twitter.com/i/search/timeline")
+	require.Nil(t, err)
+	assert.Equal(t, "Bearer token", request.Header.Get("Authorization"))
+	assert.Equal(t, "csrf", request.Header.Get("X-Csrf-Token"))
+
+	request, err = NewTwitterHTTP().newRequestS("https://twitter.com/i/search/timeline")
+	require.Nil(t, err)
+	assert.Equal(t, "", request.Header.Get("Authorization"))
+	assert.Equal(t, "", request.Header.Get("X-Csrf-Token"))
+}
+
+func TestUsernameValidation(t *testing.T) {
+	for _, username := range []string{"github", "a", "user_name_15chr", "A1_"} {
+		assert.True(t, isValidUsername(username), username)
+	}
+	for _, username := range []string{"", "sixteen_chars_xx", "with space", "slash/path", "@github"} {
+		assert.False(t, isValidUsername(username), username)
+	}
+
+	_, err := NewGenericFeedCursor("bad/name", FeedTypeRegular).RetrievePage()
+	var usernameErr *InvalidUsernameError
+	require.True(t, errors.As(err, &usernameErr))
+	assert.Equal(t, "bad/name", usernameErr.Username())
+}
+
+func TestContentEncoding(t *testing.T) {
+	const body = `{"test":true}`
+	compress := func(encoding string) []byte {
+		var buf bytes.Buffer
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(&buf)
+		case "deflate":
+			writer = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			writer, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "br":
+			writer = brotli.NewWriter(&buf)
+		default:
+			return []byte(body)
+		}
+		writer.Write([]byte(body))
+		writer.Close()
+		return buf.Bytes()
+	}
+
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := r.URL.Query().Get("encoding")
+			header := encoding
+			if encoding == "raw-deflate" {
+				header = "deflate"
+			}
+			w.Header().Set("Content-Encoding", header)
+			w.Write(compress(encoding))
+		}))
+	defer server.Close()
+
+	twitterHTTP := NewTwitterHTTP()
+	twitterHTTP.httpClient = client
+	for _, encoding := range []string{"", "identity", "gzip", "deflate", "raw-deflate", "br"} {
+		request, err := twitterHTTP.newRequestS("https://twitter.com/?encoding=" + encoding)
+		require.Nil(t, err)
+		structuredJSON, err := twitterHTTP.jsonRequest(request)
+		require.Nil(t, err, "Encoding: %s", encoding)
+		assert.Equal(t, map[string]interface{}{"test": true}, structuredJSON)
+	}
+
+	request, err := twitterHTTP.newRequestS("https://twitter.com/?encoding=compress")
+	require.Nil(t, err)
+	_, err = twitterHTTP.jsonRequest(request)
+	require.NotNil(t, err)
+	assert.Contains(t, errors.Unwrap(err).Error(), "Unsupported Content-Encoding")
+}
+
+func TestCorruptStreamRetry(t *testing.T) {
+	var buf bytes.Buffer
+	writer, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	writer.Write([]byte(readTextFileOrDie("testdata/items1.json")))
+	writer.Close()
+	compressed := buf.Bytes()
+
+	requests := 0
+	truncate := 1
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Content-Encoding", "deflate")
+			if requests <= truncate {
+				w.Write(compressed[:len(compressed)/2])
+			} else {
+				w.Write(compressed)
+			}
+		}))
+	defer server.Close()
+
+	twitterHTTP := NewTwitterHTTP(WithRetryPolicy(RetryPolicy{MaxRetries: 2}))
+	twitterHTTP.httpClient = client
+	request, err := twitterHTTP.newRequestS("https://twitter.com/")
+	require.Nil(t, err)
+	structuredJSON, err := twitterHTTP.jsonRequest(request)
+	require.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Contains(t, structuredJSON, "items_html")
+
+	// The error is returned once retries are exhausted.
+	requests, truncate = 0, 10
+	_, err = twitterHTTP.jsonRequest(request)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, requests)
+
+	// Backoff ends once request's context is done.
+	requests = 0
+	twitterHTTP.retryPolicy.Backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = twitterHTTP.jsonRequest(request.WithContext(ctx))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.Equal(t, 1, requests)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// Other errors are not retried.
+	requests = 0
+	twitterHTTP = NewTwitterHTTP()
+	twitterHTTP.httpClient = client
+	request, err = twitterHTTP.newRequestS("https://twitter.com/?encoding=compress")
+	require.Nil(t, err)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Encoding", "compress")
+	})
+	_, err = twitterHTTP.jsonRequest(request)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestJSONTee(t *testing.T) {
+	client, server := setupClientServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, readTextFileOrDie("testdata/items4.json"))
+		}))
+	defer server.Close()
+
+	var raw bytes.Buffer
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	cursor.SetClient(NewTwitterHTTP(WithJSONTee(&raw)))
+	cursor.client.httpClient = client
+
+	_, err := cursor.RetrievePage()
+	require.Nil(t, err)
+	assert.Equal(t, readTextFileOrDie("testdata/items4.json")+"\n", raw.String())
+}
+
+func TestFeedPageSize(t *testing.T) {
+	const body = `{"items_html": "", "min_position": "100"}`
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(body))
+	writer.Close()
+
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	})
+	defer server.Close()
+
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	cursor.client.httpClient = client
+	page, err := cursor.RetrievePage()
+	require.Nil(t, err)
+	assert.Equal(t, len(body), page.(*FeedPage).Size())
+	assert.Equal(t, 0, NewFeedPage(map[string]interface{}{}).Size())
+}
+
+func TestRequestPreview(t *testing.T) {
+	cursor := NewGenericFeedCursor("test", FeedTypeMedia, "608164787940413441")
+	request, err := cursor.RequestPreview()
+	require.Nil(t, err)
+	assert.Equal(t, "/i/profiles/show/test/media_timeline", request.URL.Path)
+	assert.Equal(t, "608164787940413441", request.URL.Query().Get("max_position"))
+	assert.Equal(t, "https://twitter.com/test/media", request.Header.Get("Referer"))
+	assert.Equal(t, DefaultUserAgent, request.Header.Get("User-Agent"))
+
+	_, err = NewGenericFeedCursor("bad/name", FeedTypeRegular).RequestPreview()
+	assert.NotNil(t, err)
+
+	search := NewSearchFeedCursor("#go from:test & more")
+	search.SetMode(SearchLatest)
+	request, err = search.RequestPreview()
+	require.Nil(t, err)
+	assert.Equal(t, "/i/search/timeline", request.URL.Path)
+	assert.Equal(t, "#go from:test & more", request.URL.Query().Get("q"))
+	assert.Equal(t, "tweets", request.URL.Query().Get("f"))
+	assert.Contains(t, request.URL.RawQuery, "q=%23go+from%3Atest+%26+more")
+}
@@ -0,0 +1,81 @@
+package rattler
+
+import "sort"
+
+// Thread is a conversation assembled from scraped tweets.
+//
+// Root is the tweet that started the conversation or nil if it hasn't been
+// captured. Replies are sorted by timestamp, oldest first.
+type Thread struct {
+	ConversationID uint64
+	Root           *Tweet
+	Replies        []*Tweet
+}
+
+// ThreadBuilder groups tweets into threads by their conversation ID.
+//
+// Tweets can be added in any order, e.g. a reply can be added before the
+// tweet it replies to.
+type ThreadBuilder struct {
+	threads map[uint64]*Thread
+	seen    map[uint64]struct{}
+}
+
+// NewThreadBuilder creates an empty ThreadBuilder.
+func NewThreadBuilder() *ThreadBuilder {
+	return &ThreadBuilder{
+		threads: make(map[uint64]*Thread),
+		seen:    make(map[uint64]struct{}),
+	}
+}
+
+// Add adds a tweet to the thread it belongs to. Tweets that have already been
+// added are ignored.
+func (t *ThreadBuilder) Add(tweet *Tweet) {
+	if _, seenAlready := t.seen[tweet.ID]; seenAlready {
+		return
+	}
+	t.seen[tweet.ID] = struct{}{}
+
+	conversationID := tweet.ConversationID
+	if conversationID == 0 {
+		conversationID = tweet.ID
+	}
+
+	thread, exists := t.threads[conversationID]
+	if !exists {
+		thread = &Thread{ConversationID: conversationID}
+		t.threads[conversationID] = thread
+	}
+	if tweet.ID == conversationID {
+		thread.Root = tweet
+	} else {
+		thread.Replies = append(thread.Replies, tweet)
+	}
+}
+
+// Threads returns assembled threads ordered by the time they were started.
+//
+// Threads without a root are ordered by their earliest captured reply.
+func (t *ThreadBuilder) Threads() []*Thread {
+	threads := make([]*Thread, 0, len(t.threads))
+	for _, thread := range t.threads {
+		sort.SliceStable(thread.Replies, func(i, j int) bool {
+			return tweetIsNewer(thread.Replies[j], thread.Replies[i])
+		})
+		threads = append(threads, thread)
+	}
+
+	sort.Slice(threads, func(i, j int) bool {
+		return tweetIsNewer(threads[j].first(), threads[i].first())
+	})
+	return threads
+}
+
+// first returns the earliest tweet of the thread. Replies must be sorted.
+func (t *Thread) first() *Tweet {
+	if t.Root != nil {
+		return t.Root
+	}
+	return t.Replies[0]
+}
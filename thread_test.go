@@ -0,0 +1,42 @@
+package rattler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThreadBuilder(t *testing.T) {
+	tweet := func(id, conversationID uint64, unixTime int64) *Tweet {
+		return &Tweet{
+			ID:             id,
+			ConversationID: conversationID,
+			Timestamp:      time.Unix(unixTime, 0),
+		}
+	}
+
+	builder := NewThreadBuilder()
+	// Replies arrive before their root.
+	builder.Add(tweet(12, 10, 300))
+	builder.Add(tweet(11, 10, 200))
+	builder.Add(tweet(10, 10, 100))
+	builder.Add(tweet(11, 10, 200))
+	// Conversation whose root hasn't been captured.
+	builder.Add(tweet(21, 20, 50))
+
+	threads := builder.Threads()
+	require.Equal(t, 2, len(threads))
+
+	assert.Equal(t, uint64(20), threads[0].ConversationID)
+	assert.Nil(t, threads[0].Root)
+	assert.Equal(t, 1, len(threads[0].Replies))
+
+	assert.Equal(t, uint64(10), threads[1].ConversationID)
+	require.NotNil(t, threads[1].Root)
+	assert.Equal(t, uint64(10), threads[1].Root.ID)
+	require.Equal(t, 2, len(threads[1].Replies))
+	assert.Equal(t, uint64(11), threads[1].Replies[0].ID)
+	assert.Equal(t, uint64(12), threads[1].Replies[1].ID)
+}
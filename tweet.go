@@ -1,24 +1,163 @@
 package rattler
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Tweet represents a single tweet.
+//
+// Username is the screen name of tweet's author, which may differ from the
+// owner of the feed (e.g. in likes feed or for retweets). AuthorVerified is
+// set when the author's name carries a verification badge.
+//
+// RawTime is the timestamp exactly as it appears in the markup (seconds since
+// Unix epoch), which Timestamp is derived from. It's empty if the markup
+// didn't include a timestamp.
+//
+// IsPinned is set for the tweet pinned at the top of user's profile. Such
+// tweet appears out of chronological order. IsRetweet is set for tweets that
+// appear in the feed because they were retweeted by feed's owner. Sensitive
+// is set for tweets whose media Twitter shows behind a sensitive content
+// warning.
+//
+// Lang is Twitter's own classification of tweet's language (e.g. "en") or an
+// empty string if it wasn't available.
+//
+// ConversationID is the ID of the tweet that started the conversation, which
+// is the tweet's own ID unless it's a reply. Feed markup doesn't identify the
+// direct parent of a reply, only the conversation root and the usernames in
+// InReplyToUsernames.
+//
+// Location is set only for tweets annotated with a place.
+//
+// Source is the name of the client the tweet was posted from (e.g. "Twitter
+// for iPhone"). Compact feed markup usually omits it, in which case it's
+// empty.
+//
+// Hashtags (without the leading '#'), Mentions (usernames) and URLs (expanded
+// links) are taken from tweet's text in the order they appear.
 type Tweet struct {
-	ID        uint64      `json:"id,string"`
-	Timestamp time.Time   `json:"timestamp"`
-	Text      string      `json:"text"`
-	Extra     interface{} `json:"embed"`
+	ID                 uint64         `json:"id,string"`
+	Username           string         `json:"username"`
+	AuthorVerified     bool           `json:"authorVerified,omitempty"`
+	Timestamp          time.Time      `json:"timestamp"`
+	RawTime            string         `json:"rawTime,omitempty"`
+	Text               string         `json:"text"`
+	Lang               string         `json:"lang"`
+	Extra              interface{}    `json:"embed"`
+	IsPinned           bool           `json:"pinned"`
+	IsRetweet          bool           `json:"retweet"`
+	Sensitive          bool           `json:"sensitive,omitempty"`
+	ConversationID     uint64         `json:"conversationID,string"`
+	InReplyToUsernames []string       `json:"inReplyToUsernames,omitempty"`
+	Location           *TweetLocation `json:"location,omitempty"`
+	Source             string         `json:"source,omitempty"`
+	Hashtags           []string       `json:"hashtags,omitempty"`
+	Mentions           []string       `json:"mentions,omitempty"`
+	URLs               []string       `json:"urls,omitempty"`
+}
+
+// TweetLocation is a place attached to tweet by its author.
+//
+// PlaceID is Twitter's identifier of the place or an empty string if the
+// markup didn't reference one.
+type TweetLocation struct {
+	Name    string `json:"name"`
+	PlaceID string `json:"placeID,omitempty"`
+}
+
+// PermalinkURL returns the canonical URL of the tweet's status page.
+//
+// If the author isn't known, the URL uses "/i/web/status/" path, which
+// Twitter redirects to the canonical one.
+func (t *Tweet) PermalinkURL() string {
+	if len(t.Username) == 0 {
+		return fmt.Sprintf("https://twitter.com/i/web/status/%d", t.ID)
+	}
+	return fmt.Sprintf("https://twitter.com/%s/status/%d", t.Username, t.ID)
+}
+
+// Equal reports whether both tweets have the same ID, text and embedded
+// object. Other fields, such as counters or markers derived from the feed, are
+// ignored.
+func (t *Tweet) Equal(other *Tweet) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	return t.ID == other.ID && t.Text == other.Text && reflect.DeepEqual(t.Extra, other.Extra)
+}
+
+// ContentHash returns a hex-encoded SHA-256 hash of tweet's text and embedded
+// object. The ID isn't part of the hash, so tweets with the same content have
+// the same hash, e.g. to detect reposts. The hash is stable across runs.
+func (t *Tweet) ContentHash() string {
+	hash := sha256.New()
+	hash.Write([]byte(t.Text))
+	hash.Write([]byte{0})
+	if t.Extra != nil {
+		// Embeds marshal deterministically and their JSON carries the type.
+		data, err := json.Marshal(t.Extra)
+		if err == nil {
+			hash.Write(data)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// HasMedia reports whether the tweet has embedded images, video or animated
+// GIF.
+func (t *Tweet) HasMedia() bool {
+	switch t.Extra.(type) {
+	case *TweetEmbeddedGallery, *TweetEmbeddedVideo, *TweetEmbeddedGIF:
+		return true
+	}
+	return false
+}
+
+// IsReply reports whether the tweet is a reply to another tweet.
+func (t *Tweet) IsReply() bool {
+	return t.ConversationID != 0 && t.ConversationID != t.ID
+}
+
+// IsQuote reports whether the tweet quotes another tweet.
+func (t *Tweet) IsQuote() bool {
+	_, ok := t.Extra.(*TweetEmbeddedQuote)
+	return ok
+}
+
+// EmbedType returns the type of tweet's embedded object as it appears in JSON
+// (e.g. "EMBED_TYPE_IMAGE") or an empty string if the tweet has none.
+func (t *Tweet) EmbedType() string {
+	return embedTypeName(t.Extra)
 }
 
 // TweetEmbeddedGallery represents multiple images embedded within tweet.
+//
+// AltTexts holds accessibility descriptions of the images and Sizes holds
+// their dimensions as hinted by the markup. Both are parallel to ImageURLs and
+// are nil if no image has the information. An image without description has
+// an empty string in its place, and an image without dimensions has zero
+// size, meaning it has to be probed.
 type TweetEmbeddedGallery struct {
 	ImageURLs []string
+	AltTexts  []string
+	Sizes     []ImageSize
+}
+
+// ImageSize is the size of an image in pixels.
+type ImageSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
 }
 
 // TweetEmbeddedVideo represents a video embedded within tweet.
@@ -26,20 +165,95 @@ type TweetEmbeddedVideo struct {
 	VideoURL string
 }
 
+// TweetEmbeddedGIF represents an animated GIF embedded within tweet.
+//
+// Twitter serves GIFs as MP4 files, which are played looped and have no
+// sound. ThumbnailURL is the URL of the still preview and may be empty.
+type TweetEmbeddedGIF struct {
+	VideoURL     string
+	ThumbnailURL string
+}
+
 // TweetEmbeddedCard represents a postcard embedded within tweet.
+//
+// ImageURL is the URL of card's preview image. It's empty if the image isn't
+// present in the markup.
 type TweetEmbeddedCard struct {
-	CardURL string
+	CardURL  string
+	ImageURL string
 }
 
 // TweetEmbeddedQuote represents a quote, that references another tweet,
 // that is embedded within tweet.
+//
+// QuotedText and QuotedDisplayName hold text and author's display name of the
+// quoted tweet as shown inline. They're empty if the markup lacks them.
 type TweetEmbeddedQuote struct {
-	QuoteURL string
+	QuoteURL          string
+	QuotedText        string
+	QuotedDisplayName string
+}
+
+// TweetEmbeddedPoll represents a poll embedded within tweet.
+//
+// EndsAt is zero if the markup doesn't include the time voting ends.
+type TweetEmbeddedPoll struct {
+	Options  []PollOption
+	EndsAt   time.Time
+	Finished bool
+}
+
+// PollOption is a choice of a poll along with its results.
+//
+// Twitter usually shows only the share of votes, in which case Votes is zero.
+type PollOption struct {
+	Label   string  `json:"label"`
+	Votes   int     `json:"votes,omitempty"`
+	Percent float64 `json:"percent"`
+}
+
+// ImageVariant enum represents a size variant of an image served by Twitter.
+type ImageVariant int
+
+const (
+	// ImageOrig is the image at its original resolution.
+	ImageOrig ImageVariant = 0
+	// ImageLarge is the image scaled down to fit 2048x2048.
+	ImageLarge ImageVariant = 1
+	// ImageMedium is the image scaled down to fit 1200x1200.
+	ImageMedium ImageVariant = 2
+	// ImageSmall is the image scaled down to fit 680x680.
+	ImageSmall ImageVariant = 3
+	// ImageThumb is a 150x150 thumbnail of the image.
+	ImageThumb ImageVariant = 4
+)
+
+// suffix returns the suffix that selects the variant when appended to image
+// URL.
+func (v ImageVariant) suffix() string {
+	switch v {
+	case ImageLarge:
+		return ":large"
+	case ImageMedium:
+		return ":medium"
+	case ImageSmall:
+		return ":small"
+	case ImageThumb:
+		return ":thumb"
+	}
+	return ":orig"
 }
 
 // GalleryDownloadResult is a result of calling Download() on an embedded
 // gallery object.
+//
+// Index is the position of the image in gallery's ImageURLs and URL is the
+// URL the image was requested from, so that a failed image can be retried on
+// its own. Both are set on success as well as on failure, except for errors
+// that don't concern a particular image, which have Index set to -1.
 type GalleryDownloadResult struct {
+	Index   int
+	URL     string
 	FileExt string
 	Body    io.ReadCloser
 	Error   error
@@ -47,78 +261,431 @@ type GalleryDownloadResult struct {
 
 // Download initiates a sequental download of all images within a Tweet.
 //
-// Returned channel can be used to read each image's entire body and file
-// extension.
-func (t *TweetEmbeddedGallery) Download() <-chan GalleryDownloadResult {
-	c := make(chan GalleryDownloadResult)
+// Images are downloaded at original resolution, unless another variant is
+// given.
+//
+// Returned channel can be used to read each image's body and file extension.
+// Bodies are streamed and an image is requested on the first read of its
+// body, so a failed request is reported by the body's Read() as
+// *MediaDownloadError rather than by result's Error. The next image is
+// delivered once the previous body has been read to the end. Closing a body
+// before its end abandons the download and closes the channel.
+//
+// No goroutine waits for the caller in between, so a caller may stop
+// receiving at any point without leaking anything.
+//
+// The download stops at the first image that fails.
+func (t *TweetEmbeddedGallery) Download(variant ...ImageVariant) <-chan GalleryDownloadResult {
+	return t.DownloadContext(context.Background(), variant...)
+}
 
-	go func() {
-		defer close(c)
+// DownloadContext is like Download(), but the requests are bound to ctx.
+//
+// Once ctx is cancelled, the request in progress is aborted and the channel is
+// closed.
+func (t *TweetEmbeddedGallery) DownloadContext(
+	ctx context.Context,
+	variant ...ImageVariant,
+) <-chan GalleryDownloadResult {
+	c := make(chan GalleryDownloadResult, 1)
+	if len(t.ImageURLs) == 0 {
+		c <- noImagesResult()
+		close(c)
+		return c
+	}
 
-		if len(t.ImageURLs) == 0 {
-			c <- GalleryDownloadResult{
-				Error: errors.New("Tweet contains no image URLs"),
+	sequence := &gallerySequence{
+		ctx:     ctx,
+		client:  NewTwitterHTTP(),
+		urls:    t.ImageURLs,
+		suffix:  imageSuffix(variant),
+		c:       c,
+		stopped: make(chan struct{}),
+	}
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				sequence.finish()
+			case <-sequence.stopped:
 			}
-			return
-		}
+		}()
+	}
+	sequence.push(0)
+	return c
+}
 
-		twitterHTTP := NewTwitterHTTP()
-		for _, rawURL := range t.ImageURLs {
-			imageVariantURL := rawURL + ":orig"
-			request, err := twitterHTTP.newRequestS(imageVariantURL)
-			if err != nil {
-				c <- GalleryDownloadResult{
-					Error: &MediaDownloadError{
-						msg:   "Unable to create HTTP request",
-						url:   imageVariantURL,
-						cause: err,
-					},
-				}
-				return
-			}
+// DownloadConcurrent is like DownloadContext(), but downloads up to
+// concurrency images at once. Non-positive concurrency means all images at
+// once.
+//
+// Results are delivered in the order downloads finish, which doesn't have to
+// be the order of images. A failed image doesn't stop the download of the
+// remaining ones. Each worker requests its next image once the caller is done
+// with the previous body, and closing any body before reading it to the end
+// abandons the whole download. Workers wait for the caller, so a caller that
+// stops receiving has to cancel ctx.
+func (t *TweetEmbeddedGallery) DownloadConcurrent(
+	ctx context.Context,
+	concurrency int,
+	variant ...ImageVariant,
+) <-chan GalleryDownloadResult {
+	suffix := imageSuffix(variant)
+	c := make(chan GalleryDownloadResult, 1)
+	if len(t.ImageURLs) == 0 {
+		c <- noImagesResult()
+		close(c)
+		return c
+	}
+	if concurrency <= 0 || concurrency > len(t.ImageURLs) {
+		concurrency = len(t.ImageURLs)
+	}
 
-			reader, err := twitterHTTP.httpRequest(request)
-			if err != nil {
-				c <- GalleryDownloadResult{
-					Error: &MediaDownloadError{
-						msg:   "Failed to execute HTTP request",
-						url:   imageVariantURL,
-						cause: err,
-					},
-				}
-				return
-			}
+	indexes := make(chan int, len(t.ImageURLs))
+	for i := range t.ImageURLs {
+		indexes <- i
+	}
+	close(indexes)
 
-			// Extract file extension.
-			var fileExt string
-			{
-				cleanURL := strings.TrimSuffix(rawURL, ":large")
-				cleanURL = strings.TrimSuffix(cleanURL, ":orig")
-				fileExt = extractFileExtFromURL(cleanURL)
-				if len(fileExt) == 0 {
-					// Fallback to using .png.
-					fileExt = "png"
+	twitterHTTP := NewTwitterHTTP()
+	stop, abandon := newGalleryStop()
+	var workers sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indexes {
+				result := downloadGalleryImage(ctx, twitterHTTP, i, t.ImageURLs[i], suffix, abandon)
+				if !sendGalleryResult(ctx, stop, c, result) {
+					return
+				}
+				if !waitGalleryBody(ctx, stop, result.Body) {
+					return
 				}
 			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(c)
+	}()
+
+	return c
+}
+
+// imageSuffix returns URL suffix of the optional image variant argument.
+func imageSuffix(variant []ImageVariant) string {
+	if len(variant) == 1 {
+		return variant[0].suffix()
+	} else if len(variant) > 1 {
+		panic("Too many arguments")
+	}
+	return ImageOrig.suffix()
+}
+
+func noImagesResult() GalleryDownloadResult {
+	return GalleryDownloadResult{
+		Index: -1,
+		Error: errors.New("Tweet contains no image URLs"),
+	}
+}
 
-			c <- GalleryDownloadResult{
-				FileExt: fileExt,
-				Body:    reader,
+// gallerySequence delivers images of a sequential download one at a time. The
+// next image is pushed by the body of the previous one once it's read to the
+// end, so nothing is left blocked if the caller stops receiving.
+type gallerySequence struct {
+	ctx     context.Context
+	client  *TwitterHTTP
+	urls    []string
+	suffix  string
+	c       chan GalleryDownloadResult
+	stopped chan struct{}
+
+	mutex sync.Mutex
+	done  bool
+}
+
+// push delivers the image with the given index, whose request is deferred
+// until its body is read. The channel has room for it, since the previous
+// body has been received by the caller.
+func (s *gallerySequence) push(index int) {
+	mediaURL := s.urls[index] + s.suffix
+	result := GalleryDownloadResult{
+		Index:   index,
+		URL:     mediaURL,
+		FileExt: mediaFileExt(s.urls[index]),
+	}
+	result.Body = &deferredBody{
+		open: func() (io.ReadCloser, error) {
+			return downloadMediaContext(s.ctx, s.client, mediaURL)
+		},
+		release: func(complete bool) {
+			if complete && index < len(s.urls)-1 {
+				s.push(index + 1)
+			} else {
+				s.finish()
 			}
+		},
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done {
+		return
+	}
+	s.c <- result
+	if index == len(s.urls)-1 {
+		s.close()
+	}
+}
+
+// finish closes the channel unless it's been closed already.
+func (s *gallerySequence) finish() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.done {
+		s.close()
+	}
+}
+
+func (s *gallerySequence) close() {
+	s.done = true
+	close(s.c)
+	close(s.stopped)
+}
+
+// deferredBody is a streamed image body whose request is made on the first
+// read. Release is called once: with true when the body has been read to the
+// end and with false when it's closed early or fails.
+type deferredBody struct {
+	open    func() (io.ReadCloser, error)
+	release func(complete bool)
+	reader  io.ReadCloser
+	err     error
+	once    sync.Once
+}
+
+func (b *deferredBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		b.reader, b.err = b.open()
+	}
+	if b.err != nil {
+		b.finish(false)
+		return 0, b.err
+	}
+	n, err := b.reader.Read(p)
+	if err == io.EOF {
+		// Later reads keep returning EOF without a new request.
+		b.reader.Close()
+		b.reader, b.err = nil, io.EOF
+		b.finish(true)
+	} else if err != nil {
+		b.finish(false)
+	}
+	return n, err
+}
+
+func (b *deferredBody) Close() error {
+	var err error
+	if b.reader != nil {
+		err = b.reader.Close()
+	}
+	b.finish(false)
+	return err
+}
+
+func (b *deferredBody) finish(complete bool) {
+	b.once.Do(func() { b.release(complete) })
+}
+
+// newGalleryStop returns a channel that's closed once the download is
+// abandoned and a function that abandons it, which may be called repeatedly.
+func newGalleryStop() (<-chan struct{}, func()) {
+	stop := make(chan struct{})
+	var once sync.Once
+	return stop, func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// sendGalleryResult writes out the result unless ctx is cancelled or the
+// download is abandoned, in which case result's body is closed.
+func sendGalleryResult(
+	ctx context.Context,
+	stop <-chan struct{},
+	c chan<- GalleryDownloadResult,
+	result GalleryDownloadResult,
+) bool {
+	select {
+	case c <- result:
+		return true
+	case <-ctx.Done():
+	case <-stop:
+	}
+	if result.Body != nil {
+		result.Body.Close()
+	}
+	return false
+}
+
+// waitGalleryBody blocks until the caller is done with the body delivered by
+// the download. Returns false if the download shouldn't continue.
+func waitGalleryBody(ctx context.Context, stop <-chan struct{}, body io.ReadCloser) bool {
+	streamed, ok := body.(*galleryBody)
+	if !ok {
+		return true
+	}
+	select {
+	case complete := <-streamed.released:
+		return complete
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// galleryBody is a streamed image body, which reports when the caller is done
+// with it: either the body has been read to the end or it has been closed.
+// Closing the body before its end abandons the download.
+type galleryBody struct {
+	io.ReadCloser
+	released chan bool
+	abandon  func()
+	once     sync.Once
+}
+
+func (b *galleryBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.release(true)
+	}
+	return n, err
+}
+
+func (b *galleryBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release(false)
+	return err
+}
+
+func (b *galleryBody) release(complete bool) {
+	b.once.Do(func() {
+		if !complete {
+			b.abandon()
 		}
-	}()
+		b.released <- complete
+	})
+}
 
-	return c
+// downloadGalleryImage starts download of a single image of a gallery. The
+// image body is streamed and abandon is called if it's closed before its end.
+func downloadGalleryImage(
+	ctx context.Context,
+	client *TwitterHTTP,
+	index int,
+	rawURL, suffix string,
+	abandon func(),
+) GalleryDownloadResult {
+	mediaURL := rawURL + suffix
+	result := GalleryDownloadResult{Index: index, URL: mediaURL}
+
+	reader, err := downloadMediaContext(ctx, client, mediaURL)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	result.FileExt = mediaFileExt(rawURL)
+	result.Body = &galleryBody{
+		ReadCloser: reader,
+		released:   make(chan bool, 1),
+		abandon:    abandon,
+	}
+	return result
+}
+
+// Download retrieves card's preview image.
+//
+// Returns image body and file extension.
+func (t *TweetEmbeddedCard) Download() (io.ReadCloser, string, error) {
+	if len(t.ImageURL) == 0 {
+		return nil, "", errors.New("Card contains no image URL")
+	}
+	reader, err := downloadMedia(NewTwitterHTTP(), t.ImageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return reader, mediaFileExt(t.ImageURL), nil
+}
+
+// Download retrieves the MP4 file of the GIF.
+//
+// Returns file body and file extension.
+func (t *TweetEmbeddedGIF) Download() (io.ReadCloser, string, error) {
+	if len(t.VideoURL) == 0 {
+		return nil, "", errors.New("GIF contains no video URL")
+	}
+	reader, err := downloadMedia(NewTwitterHTTP(), t.VideoURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return reader, mediaFileExt(t.VideoURL), nil
+}
+
+// downloadMedia initiates download of a media file.
+func downloadMedia(client *TwitterHTTP, mediaURL string) (io.ReadCloser, error) {
+	return downloadMediaContext(context.Background(), client, mediaURL)
+}
+
+// downloadMediaContext initiates download of a media file bound to ctx.
+func downloadMediaContext(ctx context.Context, client *TwitterHTTP, mediaURL string) (io.ReadCloser, error) {
+	request, err := client.newRequestS(mediaURL)
+	if err != nil {
+		return nil, &MediaDownloadError{
+			msg:   "Unable to create HTTP request",
+			url:   mediaURL,
+			cause: err,
+		}
+	}
+
+	reader, err := client.httpRequest(request.WithContext(ctx))
+	if err != nil {
+		return nil, &MediaDownloadError{
+			msg:   "Failed to execute HTTP request",
+			url:   mediaURL,
+			cause: err,
+		}
+	}
+	return reader, nil
+}
+
+// mediaFileExt extracts file extension from a media URL, falling back to
+// "png" if URL has no extension. Size variant suffix (e.g. ":large") is
+// ignored.
+func mediaFileExt(rawURL string) string {
+	cleanURL := rawURL
+	if i := strings.LastIndex(cleanURL, ":"); i > strings.LastIndex(cleanURL, "/") {
+		cleanURL = cleanURL[:i]
+	}
+	if fileExt := extractFileExtFromURL(cleanURL); len(fileExt) > 0 {
+		return fileExt
+	}
+	return "png"
 }
 
 // MarshalJSON returns TweetEmbeddedGallery encoded as a JSON bytestring.
 func (t *TweetEmbeddedGallery) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		Type      string   `json:"type"`
-		ImageURLs []string `json:"imageURLs"`
+		Type      string      `json:"type"`
+		ImageURLs []string    `json:"imageURLs"`
+		AltTexts  []string    `json:"altTexts,omitempty"`
+		Sizes     []ImageSize `json:"sizes,omitempty"`
 	}{
 		"EMBED_TYPE_IMAGE",
 		t.ImageURLs,
+		t.AltTexts,
+		t.Sizes,
 	})
 }
 
@@ -133,24 +700,182 @@ func (t *TweetEmbeddedVideo) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// MarshalJSON returns TweetEmbeddedGIF encoded as a JSON bytestring.
+func (t *TweetEmbeddedGIF) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Type         string `json:"type"`
+		VideoURL     string `json:"videoURL"`
+		ThumbnailURL string `json:"thumbnailURL,omitempty"`
+	}{
+		"EMBED_TYPE_GIF",
+		t.VideoURL,
+		t.ThumbnailURL,
+	})
+}
+
 // MarshalJSON returns TweetEmbeddedCard encoded as a JSON bytestring.
 func (t *TweetEmbeddedCard) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		Type    string `json:"type"`
-		CardURL string `json:"cardURL"`
+		Type     string `json:"type"`
+		CardURL  string `json:"cardURL"`
+		ImageURL string `json:"imageURL,omitempty"`
 	}{
 		"EMBED_TYPE_CARD",
 		t.CardURL,
+		t.ImageURL,
 	})
 }
 
 // MarshalJSON returns TweetEmbeddedQuote encoded as a JSON bytestring.
 func (t *TweetEmbeddedQuote) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		Type     string `json:"type"`
-		QuoteURL string `json:"quoteURL"`
+		Type              string `json:"type"`
+		QuoteURL          string `json:"quoteURL"`
+		QuotedText        string `json:"quotedText,omitempty"`
+		QuotedDisplayName string `json:"quotedDisplayName,omitempty"`
 	}{
 		"EMBED_TYPE_QUOTE",
 		t.QuoteURL,
+		t.QuotedText,
+		t.QuotedDisplayName,
 	})
 }
+
+// MarshalJSON returns TweetEmbeddedPoll encoded as a JSON bytestring.
+func (t *TweetEmbeddedPoll) MarshalJSON() ([]byte, error) {
+	var endsAt *time.Time
+	if !t.EndsAt.IsZero() {
+		endsAt = &t.EndsAt
+	}
+	return json.Marshal(&struct {
+		Type     string       `json:"type"`
+		Options  []PollOption `json:"options"`
+		EndsAt   *time.Time   `json:"endsAt,omitempty"`
+		Finished bool         `json:"finished"`
+	}{
+		"EMBED_TYPE_POLL",
+		t.Options,
+		endsAt,
+		t.Finished,
+	})
+}
+
+// embedTypeName returns the JSON discriminator of an embedded object or an
+// empty string if the object is nil or of unknown type.
+func embedTypeName(extra interface{}) string {
+	switch extra.(type) {
+	case *TweetEmbeddedGallery:
+		return "EMBED_TYPE_IMAGE"
+	case *TweetEmbeddedVideo:
+		return "EMBED_TYPE_VIDEO"
+	case *TweetEmbeddedGIF:
+		return "EMBED_TYPE_GIF"
+	case *TweetEmbeddedCard:
+		return "EMBED_TYPE_CARD"
+	case *TweetEmbeddedQuote:
+		return "EMBED_TYPE_QUOTE"
+	case *TweetEmbeddedPoll:
+		return "EMBED_TYPE_POLL"
+	}
+	return ""
+}
+
+// embedPrimaryURL returns the most relevant URL of an embedded object: first
+// image of a gallery, video, card or quoted tweet URL.
+func embedPrimaryURL(extra interface{}) string {
+	switch e := extra.(type) {
+	case *TweetEmbeddedGallery:
+		if len(e.ImageURLs) > 0 {
+			return e.ImageURLs[0]
+		}
+	case *TweetEmbeddedVideo:
+		return e.VideoURL
+	case *TweetEmbeddedGIF:
+		return e.VideoURL
+	case *TweetEmbeddedCard:
+		return e.CardURL
+	case *TweetEmbeddedQuote:
+		return e.QuoteURL
+	}
+	return ""
+}
+
+// UnmarshalEmbed decodes JSON produced by one of the embed types' MarshalJSON
+// methods back into the matching *TweetEmbedded* value.
+//
+// The concrete type is chosen by the "type" discriminator. A JSON null
+// decodes into a nil embed.
+func UnmarshalEmbed(data []byte) (interface{}, error) {
+	var fields struct {
+		Type              *string      `json:"type"`
+		ImageURLs         []string     `json:"imageURLs"`
+		AltTexts          []string     `json:"altTexts"`
+		Sizes             []ImageSize  `json:"sizes"`
+		VideoURL          string       `json:"videoURL"`
+		CardURL           string       `json:"cardURL"`
+		ImageURL          string       `json:"imageURL"`
+		ThumbnailURL      string       `json:"thumbnailURL"`
+		QuoteURL          string       `json:"quoteURL"`
+		QuotedText        string       `json:"quotedText"`
+		QuotedDisplayName string       `json:"quotedDisplayName"`
+		Options           []PollOption `json:"options"`
+		EndsAt            *time.Time   `json:"endsAt"`
+		Finished          bool         `json:"finished"`
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields.Type == nil {
+		return nil, errors.New("Embed JSON is missing 'type' attribute")
+	}
+
+	switch *fields.Type {
+	case "EMBED_TYPE_IMAGE":
+		return &TweetEmbeddedGallery{fields.ImageURLs, fields.AltTexts, fields.Sizes}, nil
+	case "EMBED_TYPE_VIDEO":
+		return &TweetEmbeddedVideo{fields.VideoURL}, nil
+	case "EMBED_TYPE_GIF":
+		return &TweetEmbeddedGIF{fields.VideoURL, fields.ThumbnailURL}, nil
+	case "EMBED_TYPE_CARD":
+		return &TweetEmbeddedCard{fields.CardURL, fields.ImageURL}, nil
+	case "EMBED_TYPE_QUOTE":
+		return &TweetEmbeddedQuote{fields.QuoteURL, fields.QuotedText, fields.QuotedDisplayName}, nil
+	case "EMBED_TYPE_POLL":
+		poll := &TweetEmbeddedPoll{Options: fields.Options, Finished: fields.Finished}
+		if fields.EndsAt != nil {
+			poll.EndsAt = *fields.EndsAt
+		}
+		return poll, nil
+	default:
+		return nil, fmt.Errorf("Unknown embed type '%s'", *fields.Type)
+	}
+}
+
+// UnmarshalJSON decodes a tweet from JSON, restoring the concrete type of the
+// embedded object.
+func (t *Tweet) UnmarshalJSON(data []byte) error {
+	// Alias drops the methods of Tweet to avoid infinite recursion.
+	type tweetAlias Tweet
+	aux := &struct {
+		*tweetAlias
+		Extra json.RawMessage `json:"embed"`
+	}{
+		tweetAlias: (*tweetAlias)(t),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	t.Extra = nil
+	if len(aux.Extra) > 0 {
+		extra, err := UnmarshalEmbed(aux.Extra)
+		if err != nil {
+			return err
+		}
+		t.Extra = extra
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+package rattler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbedRoundTrip(t *testing.T) {
+	embeds := []interface{}{
+		&TweetEmbeddedGallery{
+			ImageURLs: []string{"https://example.com/1.jpg", "https://example.com/2.png"},
+			AltTexts:  []string{"", "A cat"},
+			Sizes:     []ImageSize{{1200, 675}, {}},
+		},
+		&TweetEmbeddedVideo{"https://example.com/video.mp4"},
+		&TweetEmbeddedGIF{"https://example.com/gif.mp4", "https://example.com/gif.jpg"},
+		&TweetEmbeddedCard{"https://example.com/card", "https://example.com/card.jpg"},
+		&TweetEmbeddedQuote{QuoteURL: "https://twitter.com/test/status/1"},
+		&TweetEmbeddedQuote{"https://twitter.com/test/status/1", "Quoted text", "Test"},
+		&TweetEmbeddedPoll{
+			Options:  []PollOption{{"Yes", 5, 62.5}, {"No", 3, 37.5}},
+			EndsAt:   time.Unix(1525304774, 0).UTC(),
+			Finished: true,
+		},
+		&TweetEmbeddedPoll{Options: []PollOption{{Label: "A"}}},
+	}
+
+	for _, embed := range embeds {
+		data, err := json.Marshal(embed)
+		require.Nil(t, err)
+
+		decoded, err := UnmarshalEmbed(data)
+		require.Nil(t, err)
+		assert.Equal(t, embed, decoded)
+	}
+}
+
+func TestTweetPredicates(t *testing.T) {
+	cases := []struct {
+		tweet     Tweet
+		embedType string
+		hasMedia  bool
+		isQuote   bool
+		isReply   bool
+	}{
+		{Tweet{}, "", false, false, false},
+		{Tweet{Extra: &TweetEmbeddedGallery{}}, "EMBED_TYPE_IMAGE", true, false, false},
+		{Tweet{Extra: &TweetEmbeddedVideo{}}, "EMBED_TYPE_VIDEO", true, false, false},
+		{Tweet{Extra: &TweetEmbeddedGIF{}}, "EMBED_TYPE_GIF", true, false, false},
+		{Tweet{Extra: &TweetEmbeddedCard{}}, "EMBED_TYPE_CARD", false, false, false},
+		{Tweet{Extra: &TweetEmbeddedQuote{}}, "EMBED_TYPE_QUOTE", false, true, false},
+		{Tweet{ID: 2, ConversationID: 1}, "", false, false, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.embedType, c.tweet.EmbedType())
+		assert.Equal(t, c.hasMedia, c.tweet.HasMedia(), c.embedType)
+		assert.Equal(t, c.isQuote, c.tweet.IsQuote(), c.embedType)
+		assert.Equal(t, c.isReply, c.tweet.IsReply(), c.embedType)
+	}
+}
+
+func TestPermalinkURL(t *testing.T) {
+	tweet := &Tweet{ID: 991826226405818368, Username: "Twitter"}
+	assert.Equal(t, "https://twitter.com/Twitter/status/991826226405818368", tweet.PermalinkURL())
+
+	tweet.Username = ""
+	assert.Equal(t, "https://twitter.com/i/web/status/991826226405818368", tweet.PermalinkURL())
+}
+
+func TestTweetEqualAndContentHash(t *testing.T) {
+	newTweet := func() *Tweet {
+		return &Tweet{
+			ID:        1,
+			Text:      "Hello",
+			Timestamp: time.Unix(1525304774, 0),
+			Extra:     &TweetEmbeddedGallery{ImageURLs: []string{"https://example.com/1.jpg"}},
+		}
+	}
+	tweet := newTweet()
+	other := newTweet()
+	other.IsPinned = true
+	assert.True(t, tweet.Equal(other))
+	assert.Equal(t, tweet.ContentHash(), other.ContentHash())
+
+	other.Extra = &TweetEmbeddedGallery{ImageURLs: []string{"https://example.com/2.jpg"}}
+	assert.False(t, tweet.Equal(other))
+	assert.NotEqual(t, tweet.ContentHash(), other.ContentHash())
+
+	// Reposted content has a different ID, but the same hash.
+	other = newTweet()
+	other.ID = 2
+	assert.False(t, tweet.Equal(other))
+	assert.Equal(t, tweet.ContentHash(), other.ContentHash())
+
+	assert.Equal(t, 64, len(tweet.ContentHash()))
+
+	var missing *Tweet
+	assert.True(t, missing.Equal(nil))
+	assert.False(t, tweet.Equal(nil))
+}
+
+func TestUnmarshalEmbedUnknownType(t *testing.T) {
+	embed, err := UnmarshalEmbed([]byte(`{"type":"EMBED_TYPE_HOLOGRAM"}`))
+	assert.Nil(t, embed)
+	assert.NotNil(t, err)
+
+	embed, err = UnmarshalEmbed([]byte(`{"imageURLs":[]}`))
+	assert.Nil(t, embed)
+	assert.NotNil(t, err)
+}
+
+func TestTweetJSONRoundTrip(t *testing.T) {
+	tweet := &Tweet{
+		ID:        991826226405818368,
+		Timestamp: time.Unix(1525304774, 0).UTC(),
+		Text:      "Hello",
+		Extra:     &TweetEmbeddedQuote{QuoteURL: "https://twitter.com/test/status/1"},
+	}
+	tweet.ConversationID = 991826226405818000
+	tweet.InReplyToUsernames = []string{"test"}
+
+	data, err := json.Marshal(tweet)
+	require.Nil(t, err)
+
+	var decoded Tweet
+	require.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, tweet, &decoded)
+
+	// Tweets without embeds must decode with a nil Extra.
+	tweet.Extra = nil
+	data, err = json.Marshal(tweet)
+	require.Nil(t, err)
+	decoded = Tweet{}
+	require.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Nil(t, decoded.Extra)
+}
+
+func TestTweetCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewTweetCSVWriter(&buf)
+	require.Nil(t, writer.WriteHeader())
+	require.Nil(t, writer.Write(&Tweet{
+		ID:        1,
+		Timestamp: time.Unix(1525304774, 0).UTC(),
+		Text:      "First line,\n\"second\" line",
+		Extra:     &TweetEmbeddedGallery{ImageURLs: []string{"https://example.com/1.jpg"}},
+	}))
+	require.Nil(t, writer.Flush())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+	assert.Equal(t, []string{
+		"1",
+		"2018-05-02T23:46:14Z",
+		"First line,\n\"second\" line",
+		"EMBED_TYPE_IMAGE",
+		"https://example.com/1.jpg",
+	}, records[1])
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	tweet := &Tweet{
+		ID:        1,
+		Username:  "user",
+		Timestamp: time.Unix(1525304774, 0).UTC(),
+		Text:      "First *line*\nsecond line",
+		Extra: &TweetEmbeddedGallery{
+			ImageURLs: []string{"https://example.com/1.jpg", "https://example.com/2.jpg"},
+			AltTexts:  []string{"A cat", ""},
+		},
+	}
+	assert.Equal(t, "> First \\*line\\*\\\n"+
+		"> second line\n"+
+		">\n"+
+		"> ![A cat](https://example.com/1.jpg)\n"+
+		"> ![Image 2](https://example.com/2.jpg)\n"+
+		">\n"+
+		"> — [@user](https://twitter.com/user/status/1), 2018-05-02 23:46 UTC\n",
+		RenderMarkdown(tweet))
+
+	tweet = &Tweet{
+		ID:    2,
+		Text:  "Look",
+		Extra: &TweetEmbeddedQuote{QuoteURL: "https://twitter.com/other/status/3"},
+	}
+	assert.Equal(t, "> Look\n"+
+		">\n"+
+		"> > [Quoted tweet](https://twitter.com/other/status/3)\n"+
+		">\n"+
+		"> — [Tweet](https://twitter.com/i/web/status/2)\n",
+		RenderMarkdown(tweet))
+
+	tweet.Extra = &TweetEmbeddedQuote{"https://twitter.com/other/status/3", "Quoted", "Other"}
+	assert.Contains(t, RenderMarkdown(tweet), "> > **Other**\n"+
+		"> >\n"+
+		"> > Quoted\n"+
+		"> >\n"+
+		"> > [Quoted tweet](https://twitter.com/other/status/3)\n")
+
+	tweet.Extra = &TweetEmbeddedPoll{Options: []PollOption{{Label: "Yes", Percent: 62.5}}}
+	assert.Contains(t, RenderMarkdown(tweet), "> - Yes: 62.5%\n")
+}
+
+func TestGalleryDownloadContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.jpg:orig" {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, "image")
+	}))
+	defer server.Close()
+
+	gallery := &TweetEmbeddedGallery{
+		ImageURLs: []string{server.URL + "/fast.jpg", server.URL + "/slow.jpg"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := gallery.DownloadContext(ctx)
+
+	result := <-results
+	require.Nil(t, result.Error)
+	assert.Equal(t, "jpg", result.FileExt)
+	data, err := ioutil.ReadAll(result.Body)
+	require.Nil(t, err)
+	result.Body.Close()
+	assert.Equal(t, "image", string(data))
+
+	// Cancellation aborts the request of the slow image.
+	result = <-results
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = ioutil.ReadAll(result.Body)
+	assert.NotNil(t, err)
+	select {
+	case _, ok := <-results:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Download wasn't cancelled")
+	}
+}
+
+func TestGalleryDownloadAbandoned(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, "image")
+	}))
+	defer server.Close()
+
+	gallery := &TweetEmbeddedGallery{
+		ImageURLs: []string{server.URL + "/1.jpg", server.URL + "/2.jpg", server.URL + "/3.jpg"},
+	}
+	baseline := runtime.NumGoroutine()
+
+	// The first image is closed unread, read and closed, or read and left
+	// open before the caller stops receiving.
+	readFirst := func(read, close bool) {
+		for result := range gallery.Download() {
+			require.Nil(t, result.Error)
+			if read {
+				data, err := ioutil.ReadAll(result.Body)
+				require.Nil(t, err)
+				assert.Equal(t, "image", string(data))
+			}
+			if close {
+				result.Body.Close()
+			}
+			return
+		}
+	}
+	for i := 0; i < 5; i++ {
+		readFirst(false, true)
+		readFirst(true, true)
+		readFirst(true, false)
+	}
+
+	// Keep-alive connections have goroutines of their own, so they're closed
+	// once the downloads finish.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		sharedTransport().CloseIdleConnections()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= baseline,
+		"%d goroutines, expected at most %d", runtime.NumGoroutine(), baseline)
+	// Images after the abandoned one aren't requested, nor is an image that's
+	// closed unread.
+	assert.Equal(t, int32(10), atomic.LoadInt32(&requests))
+}
+
+func TestTweetJSONL(t *testing.T) {
+	tweets := []*Tweet{
+		{
+			ID:        1,
+			Username:  "test",
+			Timestamp: time.Unix(1525304774, 0).UTC(),
+			Text:      "First line\nsecond line",
+			Extra:     &TweetEmbeddedQuote{QuoteURL: "https://twitter.com/test/status/2"},
+		},
+		{
+			ID:             3,
+			Timestamp:      time.Unix(1525304775, 0).UTC(),
+			ConversationID: 1,
+		},
+	}
+
+	var buf bytes.Buffer
+	compressed := gzip.NewWriter(&buf)
+	writer := NewTweetJSONLWriter(compressed)
+	for _, tweet := range tweets {
+		require.Nil(t, writer.Write(tweet))
+	}
+
+	// Records are readable before the writer is closed.
+	reader, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.Nil(t, err)
+	partial, _ := ReadTweetsJSONL(reader)
+	assert.Equal(t, tweets, partial)
+
+	require.Nil(t, writer.Close())
+	reader, err = gzip.NewReader(&buf)
+	require.Nil(t, err)
+	decoded, err := ReadTweetsJSONL(reader)
+	require.Nil(t, err)
+	assert.Equal(t, tweets, decoded)
+
+	_, err = ReadTweetsJSONL(bytes.NewReader([]byte("{\"id\":\"1\"}\n\n{")))
+	assert.NotNil(t, err)
+}
+
+func TestGalleryDownloadVariant(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, "image")
+	}))
+	defer server.Close()
+
+	gallery := &TweetEmbeddedGallery{ImageURLs: []string{server.URL + "/a.jpg"}}
+	for result := range gallery.Download(ImageThumb) {
+		require.Nil(t, result.Error)
+		assert.Equal(t, "jpg", result.FileExt)
+		ioutil.ReadAll(result.Body)
+	}
+	for result := range gallery.Download() {
+		ioutil.ReadAll(result.Body)
+	}
+	assert.Equal(t, []string{"/a.jpg:thumb", "/a.jpg:orig"}, paths)
+
+	assert.Equal(t, "jpg", mediaFileExt("https://pbs.twimg.com/media/a.jpg:small"))
+	assert.Equal(t, "png", mediaFileExt("https://pbs.twimg.com/media/a.png"))
+	assert.Equal(t, "png", mediaFileExt("http://localhost:8080/media/a"))
+}
+
+func TestGalleryDownloadConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/2.jpg:orig" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	gallery := &TweetEmbeddedGallery{
+		ImageURLs: []string{server.URL + "/1.jpg", server.URL + "/2.jpg", server.URL + "/3.jpg"},
+	}
+	results := map[int]GalleryDownloadResult{}
+	bodies := map[int]string{}
+	for result := range gallery.DownloadConcurrent(context.Background(), 2) {
+		results[result.Index] = result
+		if result.Body != nil {
+			body, err := ioutil.ReadAll(result.Body)
+			require.Nil(t, err)
+			result.Body.Close()
+			bodies[result.Index] = string(body)
+		}
+	}
+	require.Equal(t, 3, len(results))
+	for i, imageURL := range gallery.ImageURLs {
+		assert.Equal(t, imageURL+":orig", results[i].URL)
+	}
+	assert.NotNil(t, results[1].Error)
+	for _, i := range []int{0, 2} {
+		require.Nil(t, results[i].Error)
+		assert.Equal(t, fmt.Sprintf("/%d.jpg:orig", i+1), bodies[i])
+	}
+
+	// Sequential download stops at the failed image, whose body reports the
+	// failure.
+	var indexes []int
+	var errs []error
+	for result := range gallery.Download() {
+		require.Nil(t, result.Error)
+		indexes = append(indexes, result.Index)
+		_, err := ioutil.ReadAll(result.Body)
+		result.Body.Close()
+		errs = append(errs, err)
+	}
+	assert.Equal(t, []int{0, 1}, indexes)
+	assert.Nil(t, errs[0])
+	assert.IsType(t, &MediaDownloadError{}, errs[1])
+
+	for result := range (&TweetEmbeddedGallery{}).DownloadConcurrent(context.Background(), 0) {
+		assert.Equal(t, -1, result.Index)
+		assert.NotNil(t, result.Error)
+	}
+}
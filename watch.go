@@ -0,0 +1,119 @@
+package rattler
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWatchInterval is the time between polls made by Watch(), when no
+// interval is given and the page doesn't suggest one.
+const DefaultWatchInterval = 30 * time.Second
+
+// Watch polls the page at cursor's position, which is normally the top of the
+// feed, and emits tweets that haven't been seen before.
+//
+// Tweets of the first poll are all emitted. After that, only tweets with IDs
+// greater than the greatest ID seen so far are emitted, so a pinned tweet or
+// a tweet that got deleted and reappeared doesn't resurface. Tweets are also
+// checked against session's duplicate suppression.
+//
+// If interval isn't positive, the interval suggested by Twitter is used,
+// falling back to DefaultWatchInterval. Errors are reported as results and
+// don't stop the polling. The returned channel is closed once ctx is
+// cancelled.
+//
+// The cursor is never advanced, so Watch() shouldn't be combined with
+// FeedIter() on the same session.
+//
+// Closing the session stops polling the same way cancelling ctx does. On a
+// session that's already closed the only result carries ErrSessionClosed.
+func (t *TwitterSession) Watch(ctx context.Context, interval time.Duration) <-chan FeedIterResult {
+	resultChan := make(chan FeedIterResult, 5)
+
+	if !t.startWorkers(1) {
+		resultChan <- FeedIterResult{Error: ErrSessionClosed, Position: t.cursor.Position()}
+		close(resultChan)
+		return resultChan
+	}
+
+	emit := func(result FeedIterResult) bool {
+		select {
+		case resultChan <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-t.closed:
+			return false
+		}
+	}
+
+	go func() {
+		defer t.workers.Done()
+		defer close(resultChan)
+		var lastSeenID uint64
+		for {
+			wait := interval
+			position := t.cursor.Position()
+			tweets, suggested, err := t.poll()
+			if err != nil && !emit(FeedIterResult{Error: err, Position: position}) {
+				return
+			}
+
+			newestID := lastSeenID
+			for _, tweet := range tweets {
+				if tweet.ID <= lastSeenID {
+					continue
+				}
+				if t.seenTweets.Has(tweet.ID) {
+					atomic.AddInt64(&t.duplicatesDropped, 1)
+					continue
+				}
+				t.seenTweets.Add(tweet.ID)
+				if tweet.ID > newestID {
+					newestID = tweet.ID
+				}
+				atomic.AddInt64(&t.tweetsEmitted, 1)
+				if !emit(FeedIterResult{Tweet: tweet, Position: position}) {
+					return
+				}
+			}
+			lastSeenID = newestID
+
+			if wait <= 0 {
+				wait = suggested
+			}
+			if wait <= 0 {
+				wait = DefaultWatchInterval
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			case <-t.closed:
+				return
+			}
+		}
+	}()
+	return resultChan
+}
+
+// poll retrieves tweets of the page at cursor's position along with the
+// refresh interval suggested by the page, if any.
+func (t *TwitterSession) poll() ([]*Tweet, time.Duration, error) {
+	page, err := t.cursor.RetrievePage()
+	if err != nil {
+		return nil, 0, err
+	}
+	atomic.AddInt64(&t.pagesFetched, 1)
+
+	var suggested time.Duration
+	if hinted, ok := page.(interface {
+		SuggestedRefresh() (time.Duration, bool)
+	}); ok {
+		suggested, _ = hinted.SuggestedRefresh()
+	}
+
+	tweets, err := page.GetTweets()
+	return tweets, suggested, err
+}
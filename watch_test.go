@@ -0,0 +1,65 @@
+package rattler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	var items map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(readTextFileOrDie("testdata/items1.json")), &items))
+	expected, err := NewFeedPage(items).GetTweets()
+	require.Nil(t, err)
+
+	// The second poll returns the same page with one new tweet on top.
+	newTweet := `<li data-item-type="tweet" data-item-id="999999999999999999">` +
+		`<p class="tweet-text">New</p></li>`
+	polls := 0
+	client, server := setupClientServer(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		page := items
+		if polls > 1 {
+			page = map[string]interface{}{}
+			for key, value := range items {
+				page[key] = value
+			}
+			page["items_html"] = newTweet + items["items_html"].(string)
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	defer server.Close()
+
+	cursor := NewGenericFeedCursor("test", FeedTypeRegular)
+	cursor.client.httpClient = client
+	session := NewTwitterSession(cursor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := session.Watch(ctx, 10*time.Millisecond)
+
+	var ids []uint64
+	for len(ids) < len(expected)+1 {
+		result := <-results
+		require.Nil(t, result.Error)
+		ids = append(ids, result.Tweet.ID)
+	}
+	assert.Equal(t, expected[0].ID, ids[0])
+	assert.Equal(t, uint64(999999999999999999), ids[len(ids)-1])
+
+	// Later polls return nothing new.
+	select {
+	case result := <-results:
+		assert.Fail(t, "Unexpected result", "%v", result)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	for range results {
+	}
+	assert.True(t, polls >= 3)
+}